@@ -0,0 +1,67 @@
+// Command blobmigrate moves file contents still stored in bytea columns to
+// the configured blob store and leaves only their references in Postgres.
+// It can be stopped and run again at any time.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"go-project/internal/blob"
+	"go-project/internal/database"
+)
+
+func main() {
+	batch := flag.Int("batch", 50, "number of rows loaded at once")
+	dryRun := flag.Bool("dry-run", false, "only report how many values would be moved")
+	flag.Parse()
+
+	db, err := database.ConnectDB()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	counts, err := database.CountLegacyBlobs(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	total := 0
+	for column, n := range counts {
+		log.Printf("%s: %d values to move", column, n)
+		total += n
+	}
+	if *dryRun || total == 0 {
+		return
+	}
+
+	store, err := blob.New(blob.ConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Failed to configure blob store: %v", err)
+	}
+
+	ctx := context.Background()
+	moved := 0
+	for {
+		legacy, err := database.ListLegacyBlobs(db, *batch)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(legacy) == 0 {
+			break
+		}
+		for _, b := range legacy {
+			ref, err := blob.Save(ctx, store, b.Data)
+			if err != nil {
+				log.Fatalf("Failed to store %s.%s of row %d: %v", b.Table, b.Column, b.ID, err)
+			}
+			if err := database.MoveLegacyBlob(db, b, ref); err != nil {
+				log.Fatalf("Failed to update %s.%s of row %d: %v", b.Table, b.Column, b.ID, err)
+			}
+			moved++
+		}
+		log.Printf("Moved %d of %d values", moved, total)
+	}
+	log.Printf("Done, moved %d values", moved)
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
 	"time"
 	"net/http"
 
 	"go-project/internal"
+	"go-project/internal/api"
 )
 
 type MeshObject struct {
@@ -16,6 +18,11 @@ type MeshObject struct {
 }
 
 func main() {
+	api.GenerationQueue.Start(context.Background())
+	api.ScriptPool.Start(context.Background())
+	api.MeshCollector.Start(context.Background())
+	api.Webhooks.Start(context.Background())
+
 	router := internal.SetupRouter()
 	log.Fatal(http.ListenAndServe(":8080", router))
 }
\ No newline at end of file
@@ -1,49 +1,93 @@
 package api
 
 import (
-	"bytes"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"go-project/internal/database"
+	"go-project/internal/jobs"
+	"go-project/internal/localscript"
+	"go-project/internal/mesh"
+	"go-project/internal/provider"
 
 	"github.com/gorilla/mux"
 )
 
 var DbPool, _ = database.ConnectDB()
 
+// MeshObjectResponse describes a mesh object. Clients download the file from
+// FileURL; Data, the file as a hex string, is only sent with ?include=data
+// for older clients.
 type MeshObjectResponse struct {
 	ID         int    `json:"id"`
 	Name       string `json:"name"`
+	OwnerID    int    `json:"owner_id,omitempty"`
+	Revision   int    `json:"revision"`
+	Format     string `json:"format,omitempty"`
+	Quad       bool   `json:"quad"`
+	FaceLimit  int    `json:"face_limit,omitempty"`
 	UploadTime string `json:"upload_time"`
-	Data       string `json:"data"`
+	FileURL    string `json:"file_url"`
+	Data       string `json:"data,omitempty"`
+
+	Size           int                   `json:"size"`
+	SHA256         string                `json:"sha256,omitempty"`
+	DetectedFormat string                `json:"detected_format,omitempty"`
+	Geometry       *MeshGeometryResponse `json:"geometry,omitempty"`
+
+	Representations []MeshRepresentationResponse `json:"representations"`
 }
 
-type RequestData struct {
-	Filename string `json:"filename"`
+type MeshRepresentationResponse struct {
+	ID        int    `json:"id"`
+	Format    string `json:"format"`
+	Quad      bool   `json:"quad"`
+	FaceLimit int    `json:"face_limit,omitempty"`
+	Size      int    `json:"size"`
+	FileURL   string `json:"file_url"`
+
+	SHA256         string                `json:"sha256,omitempty"`
+	DetectedFormat string                `json:"detected_format,omitempty"`
+	Geometry       *MeshGeometryResponse `json:"geometry,omitempty"`
+}
+
+// MeshGeometryResponse is the geometry read from a mesh file when it was
+// saved. It is missing for formats that are not parsed.
+type MeshGeometryResponse struct {
+	Vertices  int                     `json:"vertices"`
+	Triangles int                     `json:"triangles"`
+	Materials int                     `json:"materials"`
+	BBox      MeshBoundingBoxResponse `json:"bbox"`
 }
 
-type SaveRequestData struct {
-	FilePath string `json:"file_path"`
-	Name     string `json:"name"`
+type MeshBoundingBoxResponse struct {
+	Min [3]float64 `json:"min"`
+	Max [3]float64 `json:"max"`
 }
 
-type ResponseData struct {
-    MeshData      json.RawMessage `json:"mesh_data"`
-    PhotoBase64   string          `json:"photo_base64,omitempty"`
+type RequestData struct {
+	Filename string `json:"filename"`
 }
 
+// RunScript queues a run of the local neural network for the image named
+// filename in localscript.ScriptDir. It runs as a local generation job of the
+// session user, charged like one from /api/generate, and is tracked via
+// GET /api/script-jobs/{id} or GET /api/jobs/{generation_job_id}.
 func RunScript(w http.ResponseWriter, r *http.Request) {
 	log.Println("Received request to run script")
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
 	var data RequestData
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil || data.Filename == "" {
@@ -51,151 +95,43 @@ func RunScript(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request data", http.StatusBadRequest)
 		return
 	}
-
-	log.Printf("Request data: %+v", data)
-
-	projectDir := "/home/ubuntu"
-	scriptDir := projectDir + "/Neiro"
-	scriptPath := "run.py"
-	outputDir := "output/0"
-	outputDirPy := "output"
-	outputFilePath := fmt.Sprintf("%s/%s/mesh.usd", scriptDir, outputDir)
-
-	photoFilePath := filepath.Join(scriptDir, outputDir, "example2.jpg")
-	log.Printf("Script output file path: %s", outputFilePath)
-
-	if err := os.Chdir(scriptDir); err != nil {
-		log.Printf("Error changing directory to %s: %v", scriptDir, err)
-		http.Error(w, fmt.Sprintf("Error changing directory: %v", err), http.StatusInternalServerError)
-		return
-	}
-	log.Printf("Changed directory to %s", scriptDir)
-
-	cmd := exec.Command("bash", "-c", fmt.Sprintf("python %s %s --output-dir %s --bake-texture",
-		scriptPath, data.Filename, outputDirPy))
-	log.Printf("Executing command: %s", cmd.String())
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Error running script: %v", err)
-		log.Printf("Script output: %s", output)
-		http.Error(w, fmt.Sprintf("Error running script: %v\nOutput:\n%s", err, output), http.StatusInternalServerError)
-		return
-	}
-	log.Printf("Script executed successfully, output: %s", output)
-
-	saveData := SaveRequestData{FilePath: outputFilePath, Name: "GeneratedObject"}
-	saveDataBytes, _ := json.Marshal(saveData)
-
-	resp, err := http.Post("http://90.156.217.78:8080/api/mesh", "application/json", bytes.NewBuffer(saveDataBytes))
-	if err != nil {
-		log.Printf("Failed to send save mesh request: %v", err)
-		http.Error(w, "Failed to save mesh object 1 part", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-	log.Printf("Received response from save mesh API with status: %d", resp.StatusCode)
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Save mesh API returned an error: %d", resp.StatusCode)
-		http.Error(w, "Failed to save mesh object impossible", resp.StatusCode)
-		return
-	}
-
-	var saveResponse map[string]int
-	if err := json.NewDecoder(resp.Body).Decode(&saveResponse); err != nil {
-		log.Printf("Failed to decode save response: %v", err)
-		http.Error(w, "Failed to decode save response", http.StatusInternalServerError)
-		return
-	}
-
-	getMeshURL := fmt.Sprintf("http://90.156.217.78:8080/api/mesh/%d", saveResponse["id"])
-	log.Printf("Fetching saved mesh data from URL: %s", getMeshURL)
-
-	meshResp, err := http.Get(getMeshURL)
-	if err != nil {
-		log.Printf("Failed to fetch saved mesh data: %v", err)
-		http.Error(w, "Failed to retrieve saved object", http.StatusInternalServerError)
-		return
-	}
-	defer meshResp.Body.Close()
-
-	if meshResp.StatusCode != http.StatusOK {
-		log.Printf("Error fetching saved mesh object: %d", meshResp.StatusCode)
-		http.Error(w, "Failed to retrieve object details", meshResp.StatusCode)
-		return
-	}
-
-	meshData, err := ioutil.ReadAll(meshResp.Body)
-	if err != nil {
-		log.Printf("Failed to read mesh data: %v", err)
-		http.Error(w, "Failed to read mesh data", http.StatusInternalServerError)
-		return
-	}
-	log.Println("Successfully read mesh data")
-
-	var meshJSON json.RawMessage
-	if err := json.Unmarshal(meshData, &meshJSON); err != nil {
-		log.Printf("Failed to parse mesh data: %v", err)
-		http.Error(w, "Failed to parse mesh data", http.StatusInternalServerError)
-		return
-	}
-	log.Println("Successfully parsed mesh data")
-
-	photoData, err := ioutil.ReadFile(photoFilePath)
-	if err != nil {
-		log.Printf("Failed to read photo file at %s: %v", photoFilePath, err)
-		http.Error(w, "Failed to read photo file", http.StatusInternalServerError)
+	if _, err := localscript.InputPath(data.Filename); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	photoBase64 := base64.StdEncoding.EncodeToString(photoData)
-	log.Println("Successfully encoded photo to base64")
-
-	response := ResponseData{
-		MeshData:    meshJSON,
-		PhotoBase64: photoBase64,
-	}
-	responseBytes, _ := json.Marshal(response)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(responseBytes)
-}
 
-func SaveMeshObjectHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("Received request to save mesh object")
+	log.Printf("Request data: %+v", data)
 
-	var requestData SaveRequestData
-	err := json.NewDecoder(r.Body).Decode(&requestData)
-	if err != nil || requestData.FilePath == "" || requestData.Name == "" {
-		log.Printf("Invalid request data: %v", err)
-		http.Error(w, "Invalid request data", http.StatusBadRequest)
+	job, err := GenerationQueue.Enqueue(jobs.Request{
+		Filename:    data.Filename,
+		Conversions: []provider.Input{{Format: "USD"}},
+		Backend:     jobs.BackendLocal,
+		UserID:      userID,
+		ClientID:    clientID(userID),
+	})
+	if errors.Is(err, database.ErrInsufficientCredits) {
+		http.Error(w, "Insufficient credits", http.StatusPaymentRequired)
 		return
 	}
-
-	data, err := ioutil.ReadFile(requestData.FilePath)
-	if err != nil {
-		log.Printf("Failed to read file at %s: %v", requestData.FilePath, err)
-		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+	if errors.Is(err, localscript.ErrQueueFull) {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "Too many script runs queued, try again later", http.StatusServiceUnavailable)
 		return
 	}
-	log.Println("Successfully read file data")
-
-	meshID, err := database.SaveMeshObject(DbPool, requestData.Name, data)
 	if err != nil {
-		log.Printf("Failed to save object to database: %v", err)
-		http.Error(w, "Failed to save object", http.StatusInternalServerError)
+		log.Printf("Failed to queue script job: %v", err)
+		http.Error(w, "Failed to queue script job", http.StatusInternalServerError)
 		return
 	}
-	log.Printf("Successfully saved mesh object with ID: %d", meshID)
+	log.Printf("Queued script job %d of job %d for file %s", job.ScriptJobID, job.ID, data.Filename)
 
-	response := map[string]int{"id": meshID}
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Failed to send response: %v", err)
-		http.Error(w, "Failed to send response", http.StatusInternalServerError)
-		return
-	}
-	log.Println("Response sent successfully")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int{
+		"job_id":            job.ScriptJobID,
+		"generation_job_id": job.ID,
+		"queue_depth":       ScriptPool.QueueDepth(),
+	})
 }
 
 func GetMeshObjectHandler(w http.ResponseWriter, r *http.Request) {
@@ -213,18 +149,69 @@ func GetMeshObjectHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("Parsed ID: %d", id)
 
-	mesh, err := database.GetMeshObjectByID(DbPool, id)
+	// ?format= returns another representation of the mesh instead of the
+	// one it was saved with, ?revision= an older revision.
+	revision, err := parseRevisionParam(r)
 	if err != nil {
-		log.Printf("Failed to fetch object with ID %d: %v", id, err)
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+	withData := r.URL.Query().Get("include") == "data"
+	load := Meshes.Describe
+	if withData {
+		load = Meshes.Get
+	}
+	m, err := load(id, revision, r.URL.Query().Get("format"))
+	if errors.Is(err, mesh.ErrNotFound) {
 		http.Error(w, "Object not found", http.StatusNotFound)
 		return
 	}
+	if errors.Is(err, mesh.ErrRevisionNotFound) {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, mesh.ErrRepresentationNotFound) {
+		http.Error(w, "Representation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch object with ID %d: %v", id, err)
+		http.Error(w, "Failed to fetch object", http.StatusInternalServerError)
+		return
+	}
 
 	response := MeshObjectResponse{
-		ID:         mesh.ID,
-		Name:       mesh.Name,
-		UploadTime: mesh.UploadTime.Format("2006-01-02 15:04:05"),
-		Data:       fmt.Sprintf("%x", mesh.Data),
+		ID:              m.ID,
+		Name:            m.Name,
+		OwnerID:         m.OwnerID,
+		Revision:        m.Revision,
+		Format:          m.Format,
+		Quad:            m.Quad,
+		FaceLimit:       m.FaceLimit,
+		UploadTime:      m.UploadTime.Format("2006-01-02 15:04:05"),
+		FileURL:         meshFileURL(m.ID, revision, r.URL.Query().Get("format")),
+		Size:            m.Blob.Size,
+		SHA256:          m.Blob.SHA256,
+		DetectedFormat:  m.DetectedFormat,
+		Geometry:        meshGeometryResponse(m.Geometry),
+		Representations: make([]MeshRepresentationResponse, 0, len(m.Representations)),
+	}
+	if withData {
+		response.Data = fmt.Sprintf("%x", m.Data)
+	}
+	for _, rep := range m.Representations {
+		response.Representations = append(response.Representations, MeshRepresentationResponse{
+			ID:        rep.ID,
+			Format:    rep.Format,
+			Quad:      rep.Quad,
+			FaceLimit: rep.FaceLimit,
+			Size:      rep.Blob.Size,
+			FileURL:   meshFileURL(m.ID, 0, rep.Format),
+
+			SHA256:         rep.Blob.SHA256,
+			DetectedFormat: rep.DetectedFormat,
+			Geometry:       meshGeometryResponse(rep.Geometry),
+		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -276,3 +263,43 @@ func UploadImage(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Println("Response sent successfully")
 }
+
+// meshFileURL links to the file of a mesh. Revision 0 is the current one.
+func meshFileURL(id int, revision int, format string) string {
+	query := url.Values{}
+	if revision != 0 {
+		query.Set("revision", strconv.Itoa(revision))
+	}
+	if format != "" {
+		query.Set("format", strings.ToUpper(format))
+	}
+	if len(query) == 0 {
+		return fmt.Sprintf("/api/mesh/%d/file", id)
+	}
+	return fmt.Sprintf("/api/mesh/%d/file?%s", id, query.Encode())
+}
+
+// parseRevisionParam reads ?revision=, which is 0 when not given.
+func parseRevisionParam(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("revision")
+	if value == "" {
+		return 0, nil
+	}
+	revision, err := strconv.Atoi(value)
+	if err != nil || revision < 1 {
+		return 0, fmt.Errorf("invalid revision %q", value)
+	}
+	return revision, nil
+}
+
+func meshGeometryResponse(g *database.MeshGeometry) *MeshGeometryResponse {
+	if g == nil {
+		return nil
+	}
+	return &MeshGeometryResponse{
+		Vertices:  g.Vertices,
+		Triangles: g.Triangles,
+		Materials: g.Materials,
+		BBox:      MeshBoundingBoxResponse{Min: g.BBoxMin, Max: g.BBoxMax},
+	}
+}
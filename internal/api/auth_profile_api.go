@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
+	"go-project/internal/blob"
 	"go-project/internal/database"
 )
 
@@ -14,6 +16,15 @@ type User struct {
     Password    string `json:"password"`
 }
 
+// LoginResponse carries the session token that authenticates later
+// requests as "Authorization: Bearer <token>".
+type LoginResponse struct {
+	Message   string    `json:"message"`
+	UserID    int       `json:"user_id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
 type Profile struct {
     ID         int    `json:"id"`
     UserID     int    `json:"user_id"`
@@ -61,8 +72,20 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	token, expiresAt, err := newSession(existingUser.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Login successful"})
+	json.NewEncoder(w).Encode(LoginResponse{
+		Message:   "Login successful",
+		UserID:    existingUser.ID,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
 }
 
 func UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
@@ -96,8 +119,21 @@ func AddCatalogItemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Println(item.CatalogID, item.Name, item.Object3D, item.Photo)
-	itemID, err := database.CreateItem(DbPool, item.CatalogID, item.Name, item.Object3D, item.Photo)
+	var object3D, photo blob.Ref
+	var err error
+	if len(item.Object3D) > 0 {
+		object3D, err = blob.Save(r.Context(), Blobs, item.Object3D)
+	}
+	if err == nil && len(item.Photo) > 0 {
+		photo, err = blob.Save(r.Context(), Blobs, item.Photo)
+	}
+	if err != nil {
+		fmt.Print(err)
+		http.Error(w, "Failed to store item files", http.StatusInternalServerError)
+		return
+	}
+
+	itemID, err := database.CreateItem(DbPool, item.CatalogID, item.Name, object3D, photo)
 	if err != nil {
 		fmt.Print(err)
 		http.Error(w, "Failed to add item", http.StatusInternalServerError)
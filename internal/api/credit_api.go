@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-project/internal/database"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultCreditHistoryLimit = 50
+	maxCreditHistoryLimit     = 500
+)
+
+type CreditGrantRequest struct {
+	Amount      int    `json:"amount"`
+	Description string `json:"description"`
+}
+
+type CreditTransactionResponse struct {
+	ID          int       `json:"id"`
+	JobID       int       `json:"job_id,omitempty"`
+	Kind        string    `json:"kind"`
+	Amount      int       `json:"amount"`
+	Balance     int       `json:"balance"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CreditsResponse struct {
+	UserID       int                         `json:"user_id"`
+	Balance      int                         `json:"balance"`
+	Transactions []CreditTransactionResponse `json:"transactions"`
+}
+
+// GetCreditsHandler returns the balance of a user and the latest ledger
+// entries, newest first. limit caps the number of entries. Only the user
+// itself and admins may read them.
+func GetCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	if !isAdmin(r) {
+		sessionUser, ok := requireUserID(w, r)
+		if !ok {
+			return
+		}
+		if sessionUser != userID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	limit := defaultCreditHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxCreditHistoryLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	balance, err := database.GetCreditBalance(DbPool, userID)
+	if err != nil {
+		log.Printf("Failed to fetch credit balance of user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch credits", http.StatusInternalServerError)
+		return
+	}
+	transactions, err := database.ListCreditTransactions(DbPool, userID, limit)
+	if err != nil {
+		log.Printf("Failed to fetch credit transactions of user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch credits", http.StatusInternalServerError)
+		return
+	}
+
+	response := CreditsResponse{
+		UserID:       userID,
+		Balance:      balance,
+		Transactions: make([]CreditTransactionResponse, 0, len(transactions)),
+	}
+	for _, t := range transactions {
+		response.Transactions = append(response.Transactions, newCreditTransactionResponse(t))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to send response: %v", err)
+	}
+}
+
+// GrantCreditsHandler adds credits to the balance of a user. It is only
+// routed behind RequireAdmin.
+func GrantCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	var req CreditGrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if req.Amount <= 0 {
+		http.Error(w, "amount must be positive", http.StatusBadRequest)
+		return
+	}
+
+	t, err := database.GrantCredits(DbPool, userID, req.Amount, req.Description)
+	if err != nil {
+		log.Printf("Failed to grant credits to user %d: %v", userID, err)
+		http.Error(w, "Failed to grant credits", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newCreditTransactionResponse(*t))
+}
+
+func newCreditTransactionResponse(t database.CreditTransaction) CreditTransactionResponse {
+	return CreditTransactionResponse{
+		ID:          t.ID,
+		JobID:       t.JobID,
+		Kind:        t.Kind,
+		Amount:      t.Amount,
+		Balance:     t.Balance,
+		Description: t.Description,
+		CreatedAt:   t.CreatedAt,
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"go-project/internal/database"
+	"go-project/internal/jobs"
+	"go-project/internal/localscript"
+)
+
+// Generation backends. BackendAuto uses the cloud provider and falls back to
+// the local script when the user is out of credits or the provider fails.
+const (
+	BackendAuto  = "auto"
+	BackendCloud = jobs.BackendCloud
+	BackendLocal = jobs.BackendLocal
+)
+
+// DefaultBackend is used when a request does not name a backend. It is set
+// by GENERATION_BACKEND.
+var DefaultBackend = BackendAuto
+
+type GenerateResponse struct {
+	Backend         string `json:"backend"`
+	JobID           int    `json:"job_id"`
+	Status          string `json:"status"`
+	StatusURL       string `json:"status_url"`
+	MeshID          int    `json:"mesh_id,omitempty"`
+	CachedFromJobID int    `json:"cached_from_job_id,omitempty"`
+	CallbackSecret  string `json:"callback_secret,omitempty"`
+	FallbackReason  string `json:"fallback_reason,omitempty"`
+}
+
+func validBackend(backend string) bool {
+	return backend == BackendAuto || backend == BackendCloud || backend == BackendLocal
+}
+
+// GenerateHandler starts a generation on the backend chosen by the backend
+// form field or DefaultBackend. It accepts the same form as ProcessAll and
+// both backends run a generation job tracked via GET /api/jobs/{id}, with the
+// same credits, owner, webhooks and events. The local backend only handles
+// single images that create a new mesh object. A session token is required.
+func GenerateHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	req, key, err := parseGenerationRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.UserID = userID
+	req.ClientID = clientID(userID)
+
+	backend := r.FormValue("backend")
+	if backend == "" {
+		backend = DefaultBackend
+	}
+	if !validBackend(backend) {
+		http.Error(w, fmt.Sprintf("Invalid backend %q", backend), http.StatusBadRequest)
+		return
+	}
+	if req.Mode != jobs.ModeImage {
+		if backend == BackendLocal {
+			http.Error(w, fmt.Sprintf("The local backend does not support %s generation", req.Mode), http.StatusBadRequest)
+			return
+		}
+		backend = BackendCloud
+	}
+
+	if err := newCallbackSecret(&req); err != nil {
+		log.Printf("Failed to generate callback secret: %v", err)
+		http.Error(w, "Failed to create generation job", http.StatusInternalServerError)
+		return
+	}
+	if backend == BackendLocal {
+		req.Backend = jobs.BackendLocal
+	}
+	req.Fallback = backend == BackendAuto
+
+	job, replayed, err := enqueueGeneration(key, req)
+	if errors.Is(err, database.ErrInsufficientCredits) && backend == BackendAuto && req.TargetMeshID == 0 {
+		req.Backend = jobs.BackendLocal
+		req.FallbackReason = "insufficient credits"
+		job, replayed, err = enqueueGeneration(key, req)
+	}
+	if errors.Is(err, jobs.ErrIdempotencyKeyReused) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, jobs.ErrLocalUnsupported) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrInsufficientCredits) {
+		http.Error(w, "Insufficient credits", http.StatusPaymentRequired)
+		return
+	}
+	if errors.Is(err, localscript.ErrQueueFull) {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "Too many script runs queued, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create generation job: %v", err)
+		http.Error(w, "Failed to create generation job", http.StatusInternalServerError)
+		return
+	}
+
+	statusCode := http.StatusAccepted
+	if replayed {
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		statusCode = http.StatusOK
+	}
+	writeGenerateResponse(w, statusCode, GenerateResponse{
+		Backend:         job.Backend,
+		JobID:           job.ID,
+		Status:          job.Status,
+		StatusURL:       fmt.Sprintf("/api/jobs/%d", job.ID),
+		MeshID:          job.MeshID,
+		CachedFromJobID: job.CachedFromJobID,
+		CallbackSecret:  job.CallbackSecret,
+		FallbackReason:  job.FallbackReason,
+	})
+}
+
+func writeGenerateResponse(w http.ResponseWriter, statusCode int, response GenerateResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// runScriptJob starts the script job of a local generation job. The mesh it
+// makes belongs to the user of the job.
+func runScriptJob(job database.GenerationJob) (int, error) {
+	return ScriptPool.Submit(database.ScriptJob{
+		Filename:        job.Filename,
+		Image:           job.SourceImage,
+		ImageRef:        job.SourceImageRef,
+		OwnerID:         job.UserID,
+		GenerationJobID: job.ID,
+	})
+}
+
+// finishLocalGeneration finishes the generation job a script job ran for.
+func finishLocalGeneration(scriptJob database.ScriptJob) {
+	if scriptJob.GenerationJobID == 0 {
+		return
+	}
+	outcome := database.GenerationJob{
+		ID:          scriptJob.GenerationJobID,
+		Status:      jobs.StatusFailed,
+		Stage:       jobs.StageGenerate,
+		Error:       scriptJob.Error,
+		ScriptJobID: scriptJob.ID,
+	}
+	if scriptJob.Status == localscript.StatusSucceeded {
+		outcome.Status = jobs.StatusSucceeded
+		outcome.Stage = jobs.StageDone
+		outcome.Progress = 100
+		outcome.MeshID = scriptJob.MeshID
+	}
+	GenerationQueue.FinishLocal(outcome)
+}
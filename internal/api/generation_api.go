@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-project/internal/blob"
+	"go-project/internal/database"
+	"go-project/internal/jobs"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+type GenerationResponse struct {
+	ID             int    `json:"id"`
+	JobID          int    `json:"job_id"`
+	Status         string `json:"status"`
+	Mode           string `json:"mode"`
+	Filename       string `json:"filename,omitempty"`
+	SourceImageURL string `json:"source_image_url,omitempty"`
+	Prompt         string `json:"prompt,omitempty"`
+	NegativePrompt string `json:"negative_prompt,omitempty"`
+	GenerateTaskID string `json:"generate_task_id,omitempty"`
+	ModelURL       string `json:"model_url,omitempty"`
+	ModelSize      int    `json:"model_size,omitempty"`
+	ModelFileURL   string `json:"model_file_url,omitempty"`
+	MeshID         int    `json:"mesh_id,omitempty"`
+
+	Images      []JobImageResponse             `json:"images,omitempty"`
+	Conversions []GenerationConversionResponse `json:"conversions"`
+	Stages      []GenerationStageResponse      `json:"stages"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type GenerationConversionResponse struct {
+	Format           string `json:"format"`
+	Quad             bool   `json:"quad"`
+	FaceLimit        int    `json:"face_limit"`
+	TaskID           string `json:"task_id,omitempty"`
+	Status           string `json:"status"`
+	ModelURL         string `json:"model_url,omitempty"`
+	RepresentationID int    `json:"representation_id,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+type GenerationStageResponse struct {
+	Stage      string     `json:"stage"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMs int64      `json:"duration_ms"`
+}
+
+func GetGenerationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	generation, err := database.GetGenerationByID(DbPool, id)
+	if err != nil {
+		log.Printf("Failed to fetch generation %d: %v", id, err)
+		http.Error(w, "Generation not found", http.StatusNotFound)
+		return
+	}
+	job, err := database.GetGenerationJobByID(DbPool, generation.JobID)
+	if err != nil {
+		log.Printf("Failed to fetch job %d of generation %d: %v", generation.JobID, id, err)
+		http.Error(w, "Failed to fetch generation", http.StatusInternalServerError)
+		return
+	}
+	stages, err := database.ListGenerationStages(DbPool, id)
+	if err != nil {
+		log.Printf("Failed to fetch stages of generation %d: %v", id, err)
+		http.Error(w, "Failed to fetch generation", http.StatusInternalServerError)
+		return
+	}
+
+	response := GenerationResponse{
+		ID:             generation.ID,
+		JobID:          generation.JobID,
+		Status:         generation.Status,
+		Mode:           job.Mode,
+		Filename:       job.Filename,
+		Prompt:         job.Prompt,
+		NegativePrompt: job.NegativePrompt,
+		GenerateTaskID: generation.GenerateTaskID,
+		ModelURL:       generation.ModelURL,
+		ModelSize:      generation.ModelSize,
+		MeshID:         generation.MeshID,
+		Conversions:    make([]GenerationConversionResponse, 0, len(job.Conversions)),
+		Stages:         make([]GenerationStageResponse, 0, len(stages)),
+		CreatedAt:      generation.CreatedAt,
+		FinishedAt:     generation.FinishedAt,
+	}
+	if job.Mode == jobs.ModeImage {
+		response.SourceImageURL = fmt.Sprintf("/api/generations/%d/source-image", id)
+	}
+	if generation.ModelSize > 0 {
+		response.ModelFileURL = fmt.Sprintf("/api/generations/%d/model", id)
+	}
+	for _, image := range job.Images {
+		response.Images = append(response.Images, JobImageResponse{
+			View:     image.View,
+			Filename: image.Filename,
+			Size:     image.Size,
+		})
+	}
+	for _, c := range job.Conversions {
+		response.Conversions = append(response.Conversions, GenerationConversionResponse{
+			Format:           c.Format,
+			Quad:             c.Quad,
+			FaceLimit:        c.FaceLimit,
+			TaskID:           c.TaskID,
+			Status:           c.Status,
+			ModelURL:         c.ModelURL,
+			RepresentationID: c.RepresentationID,
+			Error:            c.Error,
+		})
+	}
+	for _, s := range stages {
+		end := time.Now()
+		if s.FinishedAt != nil {
+			end = *s.FinishedAt
+		}
+		response.Stages = append(response.Stages, GenerationStageResponse{
+			Stage:      s.Stage,
+			StartedAt:  s.StartedAt,
+			FinishedAt: s.FinishedAt,
+			DurationMs: end.Sub(s.StartedAt).Milliseconds(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to send response: %v", err)
+	}
+}
+
+// GetGenerationModelHandler returns the generated model before conversion.
+func GetGenerationModelHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	ref, model, err := database.GetGenerationModel(DbPool, id)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && ref.Key == "" && model == nil) {
+		http.Error(w, "Model not found", http.StatusNotFound)
+		return
+	}
+	if err == nil && ref.Key != "" {
+		model, err = blob.Load(r.Context(), Blobs, ref)
+	}
+	if err != nil {
+		log.Printf("Failed to fetch model of generation %d: %v", id, err)
+		http.Error(w, "Failed to fetch model", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(model)
+}
+
+func GetGenerationSourceImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	filename, ref, image, err := database.GetGenerationSourceImage(DbPool, id)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && ref.Key == "" && image == nil) {
+		http.Error(w, "Source image not found", http.StatusNotFound)
+		return
+	}
+	if err == nil && ref.Key != "" {
+		image, err = blob.Load(r.Context(), Blobs, ref)
+	}
+	if err != nil {
+		log.Printf("Failed to fetch source image of generation %d: %v", id, err)
+		http.Error(w, "Failed to fetch source image", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(image))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename))
+	w.Write(image)
+}
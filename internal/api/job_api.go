@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-project/internal/database"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+type JobResponse struct {
+	ID              int       `json:"id"`
+	Status          string    `json:"status"`
+	Stage           string    `json:"stage"`
+	Mode            string    `json:"mode"`
+	Prompt          string    `json:"prompt,omitempty"`
+	NegativePrompt  string    `json:"negative_prompt,omitempty"`
+	Format          string    `json:"format"`
+	Quad            bool      `json:"quad"`
+	FaceLimit       int       `json:"face_limit"`
+	Progress        int       `json:"progress"`
+	QueuingNum      int       `json:"queuing_num"`
+	RunningLeftTime int       `json:"running_left_time"`
+	MeshID          int       `json:"mesh_id,omitempty"`
+	TargetMeshID    int       `json:"target_mesh_id,omitempty"`
+	CachedFromJobID int       `json:"cached_from_job_id,omitempty"`
+	UserID          int       `json:"user_id,omitempty"`
+	CreditsReserved int       `json:"credits_reserved"`
+	CreditsState    string    `json:"credits_state,omitempty"`
+	Backend         string    `json:"backend"`
+	FallbackReason  string    `json:"fallback_reason,omitempty"`
+	ScriptJobID     int       `json:"script_job_id,omitempty"`
+	GenerationID    int       `json:"generation_id,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+
+	Conversions []JobConversionResponse `json:"conversions"`
+	Images      []JobImageResponse      `json:"images,omitempty"`
+}
+
+type JobImageResponse struct {
+	View     string `json:"view"`
+	Filename string `json:"filename"`
+	Size     int    `json:"size"`
+}
+
+type JobConversionResponse struct {
+	Format           string `json:"format"`
+	Quad             bool   `json:"quad"`
+	FaceLimit        int    `json:"face_limit"`
+	Status           string `json:"status"`
+	Progress         int    `json:"progress"`
+	RepresentationID int    `json:"representation_id,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// userJob loads a job of the session user. Jobs of other users are answered
+// with 404 like missing ones; it returns false once it has answered.
+func userJob(w http.ResponseWriter, r *http.Request, id int) (*database.GenerationJob, bool) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return nil, false
+	}
+	job, err := database.GetGenerationJobByID(DbPool, id)
+	if errors.Is(err, pgx.ErrNoRows) || err == nil && job.UserID != userID {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to fetch job %d: %v", id, err)
+		http.Error(w, "Failed to fetch job", http.StatusInternalServerError)
+		return nil, false
+	}
+	return job, true
+}
+
+func GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	job, ok := userJob(w, r, id)
+	if !ok {
+		return
+	}
+
+	response := JobResponse{
+		ID:              job.ID,
+		Status:          job.Status,
+		Stage:           job.Stage,
+		Mode:            job.Mode,
+		Prompt:          job.Prompt,
+		NegativePrompt:  job.NegativePrompt,
+		Format:          job.Format,
+		Quad:            job.Quad,
+		FaceLimit:       job.FaceLimit,
+		Progress:        job.Progress,
+		QueuingNum:      job.QueuingNum,
+		RunningLeftTime: job.RunningLeftTime,
+		MeshID:          job.MeshID,
+		TargetMeshID:    job.TargetMeshID,
+		CachedFromJobID: job.CachedFromJobID,
+		UserID:          job.UserID,
+		CreditsReserved: job.CreditsReserved,
+		CreditsState:    job.CreditsState,
+		Backend:         job.Backend,
+		FallbackReason:  job.FallbackReason,
+		ScriptJobID:     job.ScriptJobID,
+		Error:           job.Error,
+		CreatedAt:       job.CreatedAt,
+		UpdatedAt:       job.UpdatedAt,
+		Conversions:     make([]JobConversionResponse, 0, len(job.Conversions)),
+	}
+	response.GenerationID, err = database.GetGenerationIDByJobID(DbPool, id)
+	if err != nil {
+		log.Printf("Failed to fetch generation of job %d: %v", id, err)
+	}
+	for _, c := range job.Conversions {
+		response.Conversions = append(response.Conversions, JobConversionResponse{
+			Format:           c.Format,
+			Quad:             c.Quad,
+			FaceLimit:        c.FaceLimit,
+			Status:           c.Status,
+			Progress:         c.Progress,
+			RepresentationID: c.RepresentationID,
+			Error:            c.Error,
+		})
+	}
+	for _, image := range job.Images {
+		response.Images = append(response.Images, JobImageResponse{
+			View:     image.View,
+			Filename: image.Filename,
+			Size:     image.Size,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to send response: %v", err)
+	}
+}
+
+func CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	if _, ok := userJob(w, r, id); !ok {
+		return
+	}
+
+	cancelled, err := GenerationQueue.Cancel(id)
+	if err != nil {
+		log.Printf("Failed to cancel job %d: %v", id, err)
+		http.Error(w, "Failed to cancel job", http.StatusInternalServerError)
+		return
+	}
+	if !cancelled {
+		http.Error(w, "Job not found or already finished", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Job cancelled"})
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-project/internal/database"
+	"go-project/internal/jobs"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// eventsRefreshInterval is how often an open stream re-reads the job from the
+// database. It keeps idle connections alive and ends streams of jobs that
+// finished in another process.
+const eventsRefreshInterval = 15 * time.Second
+
+var upgrader = websocket.Upgrader{CheckOrigin: checkOrigin}
+
+// allowedOrigins are the browser origins besides the API's own that may open
+// job WebSockets. They are set by WS_ALLOWED_ORIGINS as a comma-separated
+// list.
+var allowedOrigins []string
+
+// checkOrigin accepts requests without an Origin, which do not come from a
+// browser, and those from the API's own host or from allowedOrigins.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// JobEventsHandler streams job events as Server-Sent Events until the job
+// finishes or the client disconnects.
+func JobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := GenerationQueue.Subscribe(id)
+	defer unsubscribe()
+
+	job, ok := userJob(w, r, id)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(event jobs.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	ping := func() error {
+		if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	if err := streamJobEvents(r.Context(), job, events, send, ping); err != nil {
+		log.Printf("Event stream of job %d closed: %v", id, err)
+	}
+}
+
+// JobWebSocketHandler streams the same events as JobEventsHandler as JSON
+// messages over a WebSocket.
+func JobWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	events, unsubscribe := GenerationQueue.Subscribe(id)
+	defer unsubscribe()
+
+	job, ok := userJob(w, r, id)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade connection for job %d: %v", id, err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		// Clients do not send anything, reading only detects the close.
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	send := func(event jobs.Event) error {
+		return conn.WriteJSON(event)
+	}
+	ping := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second))
+	}
+
+	err = streamJobEvents(ctx, job, events, send, ping)
+	if err != nil {
+		log.Printf("WebSocket of job %d closed: %v", id, err)
+		return
+	}
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "job finished"), time.Now().Add(5*time.Second))
+}
+
+// streamJobEvents sends the current job state followed by its live events
+// and returns once the job reaches a final status.
+func streamJobEvents(ctx context.Context, job *database.GenerationJob, events <-chan jobs.Event,
+	send func(jobs.Event) error, ping func() error) error {
+	if err := send(jobs.NewEvent(jobs.EventState, job)); err != nil {
+		return err
+	}
+	if jobs.IsFinalStatus(job.Status) {
+		return nil
+	}
+
+	ticker := time.NewTicker(eventsRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-events:
+			if err := send(event); err != nil {
+				return err
+			}
+			if event.Type == jobs.EventState && event.Final() {
+				return nil
+			}
+		case <-ticker.C:
+			current, err := database.GetGenerationJobByID(DbPool, job.ID)
+			if err == nil && jobs.IsFinalStatus(current.Status) {
+				return send(jobs.NewEvent(jobs.EventState, current))
+			}
+			if err := ping(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-project/internal/mesh"
+
+	"github.com/gorilla/mux"
+)
+
+// A deleted mesh can be restored for MESH_RESTORE_WINDOW; the collector
+// looks for expired meshes and unreferenced blobs every MESH_GC_INTERVAL.
+const (
+	defaultMeshRestoreWindow = 30 * 24 * time.Hour
+	defaultMeshGCInterval    = time.Hour
+)
+
+var meshRestoreWindow = defaultMeshRestoreWindow
+
+type MeshDeletedResponse struct {
+	ID           int       `json:"id"`
+	DeletedAt    time.Time `json:"deleted_at"`
+	RestoreUntil time.Time `json:"restore_until"`
+}
+
+// DeleteMeshObjectHandler soft-deletes a mesh object of the session user. It
+// disappears from the API at once but its files are only removed after the
+// restore window. Meshes of other users are answered with 404.
+func DeleteMeshObjectHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+
+	deletedAt, err := Meshes.Delete(id, userID)
+	if errors.Is(err, mesh.ErrNotFound) {
+		http.Error(w, "Object not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete mesh object %d: %v", id, err)
+		http.Error(w, "Failed to delete object", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Deleted mesh object %d", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MeshDeletedResponse{
+		ID:           id,
+		DeletedAt:    deletedAt,
+		RestoreUntil: deletedAt.Add(meshRestoreWindow),
+	})
+}
+
+// UndeleteMeshObjectHandler brings back a mesh object of the session user
+// deleted within the restore window.
+func UndeleteMeshObjectHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+
+	err = Meshes.Undelete(id, userID, meshRestoreWindow)
+	if errors.Is(err, mesh.ErrNotFound) {
+		http.Error(w, "No deleted object to restore", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to restore mesh object %d: %v", id, err)
+		http.Error(w, "Failed to restore object", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Restored mesh object %d", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"id": id})
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"go-project/internal/mesh"
+
+	"github.com/gorilla/mux"
+)
+
+// GetMeshFileHandler serves the raw file of a mesh object. ?format= selects
+// another representation, ?revision= an older revision and ?download=1 asks the browser to save the file
+// instead of opening it. Range and If-None-Match requests are handled by
+// http.ServeContent.
+func GetMeshFileHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	revision, err := parseRevisionParam(r)
+	if err != nil {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	m, err := Meshes.Get(id, revision, r.URL.Query().Get("format"))
+	if errors.Is(err, mesh.ErrNotFound) {
+		http.Error(w, "Object not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, mesh.ErrRevisionNotFound) {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, mesh.ErrRepresentationNotFound) {
+		http.Error(w, "Representation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch object with ID %d: %v", id, err)
+		http.Error(w, "Failed to fetch object", http.StatusInternalServerError)
+		return
+	}
+
+	// Files are stored by content, so their checksum is a strong ETag.
+	// Rows not moved to the blob store yet have no checksum stored.
+	checksum := m.Blob.SHA256
+	if checksum == "" {
+		sum := sha256.Sum256(m.Data)
+		checksum = hex.EncodeToString(sum[:])
+	}
+
+	disposition := "inline"
+	if download, _ := strconv.ParseBool(r.URL.Query().Get("download")); download {
+		disposition = "attachment"
+	}
+
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": mesh.Filename(m.Name, m.Format)}); value != "" {
+		disposition = value
+	}
+
+	w.Header().Set("Content-Type", mesh.ContentType(m.Format))
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("ETag", `"`+checksum+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", m.UploadTime, bytes.NewReader(m.Data))
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-project/internal/database"
+)
+
+const (
+	defaultMeshListLimit = 20
+	maxMeshListLimit     = 100
+	defaultMeshSort      = "upload_time"
+)
+
+type MeshListItemResponse struct {
+	ID           int      `json:"id"`
+	Name         string   `json:"name"`
+	OwnerID      int      `json:"owner_id,omitempty"`
+	Format       string   `json:"format,omitempty"`
+	Formats      []string `json:"formats"`
+	Quad         bool     `json:"quad"`
+	FaceLimit    int      `json:"face_limit,omitempty"`
+	Size         int      `json:"size"`
+	SHA256       string   `json:"sha256,omitempty"`
+	GenerationID int      `json:"generation_id,omitempty"`
+	UploadTime   string   `json:"upload_time"`
+	FileURL      string   `json:"file_url"`
+
+	DetectedFormat string                `json:"detected_format,omitempty"`
+	Geometry       *MeshGeometryResponse `json:"geometry,omitempty"`
+}
+
+type MeshListResponse struct {
+	Items      []MeshListItemResponse `json:"items"`
+	NextCursor string                 `json:"next_cursor,omitempty"`
+}
+
+// meshListCursor is the content of the opaque next_cursor token. Sort and
+// Desc tie a cursor to the ordering it was issued for.
+type meshListCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    int    `json:"i"`
+}
+
+// ListMeshObjectsHandler lists mesh objects without their files. Filters:
+// owner_id, format, name, uploaded_after, uploaded_before and generation_id.
+// sort is one of database.MeshSortKeys and order is asc or desc (the
+// default). Pages are limit long and the next one is requested by passing
+// next_cursor as cursor with the same filters.
+func ListMeshObjectsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseMeshFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := filter.Limit
+	filter.Limit++
+	items, err := database.ListMeshObjects(DbPool, filter)
+	if errors.Is(err, database.ErrInvalidMeshCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list mesh objects: %v", err)
+		http.Error(w, "Failed to list mesh objects", http.StatusInternalServerError)
+		return
+	}
+
+	response := MeshListResponse{Items: make([]MeshListItemResponse, 0, len(items))}
+	if len(items) > limit {
+		items = items[:limit]
+		next := filter.CursorAfter(items[len(items)-1])
+		response.NextCursor = encodeMeshCursor(meshListCursor{Sort: filter.Sort, Desc: filter.Desc, Value: next.Value, ID: next.ID})
+	}
+	for _, item := range items {
+		response.Items = append(response.Items, MeshListItemResponse{
+			ID:           item.ID,
+			Name:         item.Name,
+			OwnerID:      item.OwnerID,
+			Format:       item.Format,
+			Formats:      item.Formats,
+			Quad:         item.Quad,
+			FaceLimit:    item.FaceLimit,
+			Size:         item.Blob.Size,
+			SHA256:       item.Blob.SHA256,
+			GenerationID: item.GenerationID,
+			UploadTime:   item.UploadTime.Format("2006-01-02 15:04:05"),
+			FileURL:      meshFileURL(item.ID, 0, ""),
+
+			DetectedFormat: item.DetectedFormat,
+			Geometry:       meshGeometryResponse(item.Geometry),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to send response: %v", err)
+	}
+}
+
+func parseMeshFilter(query url.Values) (database.MeshFilter, error) {
+	filter := database.MeshFilter{
+		Format: strings.ToUpper(strings.TrimSpace(query.Get("format"))),
+		Name:   strings.TrimSpace(query.Get("name")),
+		Sort:   defaultMeshSort,
+		Desc:   true,
+		Limit:  defaultMeshListLimit,
+	}
+
+	var err error
+	for name, target := range map[string]*int{
+		"owner_id":      &filter.OwnerID,
+		"generation_id": &filter.GenerationID,
+		"limit":         &filter.Limit,
+	} {
+		if value := query.Get(name); value != "" {
+			if *target, err = strconv.Atoi(value); err != nil || *target < 1 {
+				return filter, fmt.Errorf("invalid %s value %q", name, value)
+			}
+		}
+	}
+	if filter.Limit > maxMeshListLimit {
+		return filter, fmt.Errorf("limit must be at most %d", maxMeshListLimit)
+	}
+
+	if filter.UploadedAfter, err = parseTimeParam(query, "uploaded_after"); err != nil {
+		return filter, err
+	}
+	if filter.UploadedBefore, err = parseTimeParam(query, "uploaded_before"); err != nil {
+		return filter, err
+	}
+
+	if value := query.Get("sort"); value != "" {
+		valid := false
+		for _, key := range database.MeshSortKeys {
+			if value == key {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return filter, fmt.Errorf("invalid sort value %q, expected one of %s", value, strings.Join(database.MeshSortKeys, ", "))
+		}
+		filter.Sort = value
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		filter.Desc = false
+	default:
+		return filter, fmt.Errorf("invalid order value %q, expected asc or desc", query.Get("order"))
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeMeshCursor(value)
+		if err != nil || cursor.Sort != filter.Sort || cursor.Desc != filter.Desc {
+			return filter, errors.New("invalid cursor")
+		}
+		filter.Cursor = &database.MeshCursor{Value: cursor.Value, ID: cursor.ID}
+	}
+	return filter, nil
+}
+
+// parseTimeParam accepts RFC 3339 timestamps and plain dates.
+func parseTimeParam(query url.Values, name string) (*time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s value %q, expected RFC 3339 time or YYYY-MM-DD", name, value)
+}
+
+func encodeMeshCursor(cursor meshListCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeMeshCursor(value string) (meshListCursor, error) {
+	var cursor meshListCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-project/internal/database"
+	"go-project/internal/mesh"
+
+	"github.com/gorilla/mux"
+)
+
+type MeshRevisionResponse struct {
+	Revision  int                    `json:"revision"`
+	Current   bool                   `json:"current"`
+	Source    string                 `json:"source,omitempty"`
+	JobID     int                    `json:"job_id,omitempty"`
+	Params    map[string]interface{} `json:"params"`
+	FileURL   string                 `json:"file_url"`
+	CreatedAt time.Time              `json:"created_at"`
+
+	Representations []MeshRepresentationResponse `json:"representations"`
+}
+
+type MeshRevisionsResponse struct {
+	MeshID          int                    `json:"mesh_id"`
+	CurrentRevision int                    `json:"current_revision"`
+	Revisions       []MeshRevisionResponse `json:"revisions"`
+}
+
+// GetMeshRevisionsHandler lists the revisions of a mesh object, newest first,
+// with the parameters each one was made with.
+func GetMeshRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	object, err := database.GetMeshObjectByID(DbPool, id)
+	if err != nil {
+		http.Error(w, "Object not found", http.StatusNotFound)
+		return
+	}
+	revisions, err := Meshes.Revisions(id)
+	if err != nil && !errors.Is(err, mesh.ErrNotFound) {
+		log.Printf("Failed to fetch revisions of mesh object %d: %v", id, err)
+		http.Error(w, "Failed to fetch revisions", http.StatusInternalServerError)
+		return
+	}
+
+	response := MeshRevisionsResponse{
+		MeshID:          id,
+		CurrentRevision: object.CurrentRevision,
+		Revisions:       make([]MeshRevisionResponse, 0, len(revisions)),
+	}
+	for _, rev := range revisions {
+		item := MeshRevisionResponse{
+			Revision:        rev.Number,
+			Current:         rev.Number == object.CurrentRevision,
+			Source:          rev.Source,
+			JobID:           rev.JobID,
+			Params:          rev.Params,
+			FileURL:         meshFileURL(id, rev.Number, ""),
+			CreatedAt:       rev.CreatedAt,
+			Representations: make([]MeshRepresentationResponse, 0, len(rev.Representations)),
+		}
+		for _, rep := range rev.Representations {
+			item.Representations = append(item.Representations, MeshRepresentationResponse{
+				ID:        rep.ID,
+				Format:    rep.Format,
+				Quad:      rep.Quad,
+				FaceLimit: rep.FaceLimit,
+				Size:      rep.Blob.Size,
+				FileURL:   meshFileURL(id, rev.Number, rep.Format),
+
+				SHA256:         rep.Blob.SHA256,
+				DetectedFormat: rep.DetectedFormat,
+				Geometry:       meshGeometryResponse(rep.Geometry),
+			})
+		}
+		response.Revisions = append(response.Revisions, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to send response: %v", err)
+	}
+}
+
+// RestoreMeshRevisionHandler makes an older revision of a mesh object the
+// current one again. Nothing is deleted, so restoring can be undone by
+// restoring another revision.
+func RestoreMeshRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	revision, err := strconv.Atoi(vars["revision"])
+	if err != nil {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	rev, err := Meshes.Restore(id, revision)
+	if errors.Is(err, mesh.ErrNotFound) {
+		http.Error(w, "Object not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, mesh.ErrRevisionNotFound) {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to restore revision %d of mesh object %d: %v", revision, id, err)
+		http.Error(w, "Failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Restored revision %d of mesh object %d", rev.Number, id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"id": id, "current_revision": rev.Number})
+}
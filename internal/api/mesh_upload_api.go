@@ -0,0 +1,232 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go-project/internal/mesh"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultMaxMeshUploadSize limits uploaded mesh files unless
+// MAX_MESH_UPLOAD_SIZE is set.
+const defaultMaxMeshUploadSize = 100 << 20
+
+// ContentSHA256Header carries the hex SHA-256 of an uploaded file. When it is
+// set the upload is rejected unless the received bytes match.
+const ContentSHA256Header = "X-Content-SHA256"
+
+var maxMeshUploadSize int64 = defaultMaxMeshUploadSize
+
+type meshUpload struct {
+	name     string
+	filename string
+	data     []byte
+	opts     mesh.Options
+	sha256   string
+}
+
+// SaveMeshObjectHandler stores a mesh uploaded by the client, either as the
+// "file" field of a multipart form or as the raw request body. Name, format,
+// quad, face_limit and sha256 come from form fields or the query string; the
+// format is detected from the file when it is not given. A session token,
+// when sent, makes its user the owner of the mesh.
+func SaveMeshObjectHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to save mesh object")
+
+	upload, checksum, ok := readVerifiedMeshUpload(w, r, true)
+	if !ok {
+		return
+	}
+
+	stored, err := Meshes.Create(upload.name, upload.data, upload.opts)
+	if err != nil {
+		log.Printf("Failed to save uploaded mesh %q: %v", upload.name, err)
+		http.Error(w, "Failed to save object", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Successfully saved mesh object with ID: %d", stored.MeshID)
+
+	writeStoredMesh(w, stored, upload, checksum)
+}
+
+// SaveMeshRevisionHandler uploads a new revision of an existing mesh object,
+// in the same way as SaveMeshObjectHandler, and makes it the current one.
+func SaveMeshRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	upload, checksum, ok := readVerifiedMeshUpload(w, r, false)
+	if !ok {
+		return
+	}
+
+	stored, err := Meshes.AddRevision(id, upload.data, upload.opts)
+	if errors.Is(err, mesh.ErrNotFound) {
+		http.Error(w, "Object not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save revision of mesh object %d: %v", id, err)
+		http.Error(w, "Failed to save revision", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Saved revision %d of mesh object %d", stored.Revision, id)
+
+	writeStoredMesh(w, stored, upload, checksum)
+}
+
+// readVerifiedMeshUpload reads an upload and checks it against the checksum
+// sent by the client. It answers the request itself when it returns false.
+func readVerifiedMeshUpload(w http.ResponseWriter, r *http.Request, requireName bool) (*meshUpload, string, bool) {
+	upload, status, err := readMeshUpload(w, r, requireName)
+	if err != nil {
+		log.Printf("Invalid mesh upload: %v", err)
+		http.Error(w, err.Error(), status)
+		return nil, "", false
+	}
+
+	sum := sha256.Sum256(upload.data)
+	checksum := hex.EncodeToString(sum[:])
+	if upload.sha256 != "" && !strings.EqualFold(upload.sha256, checksum) {
+		http.Error(w, "Checksum mismatch: the file was not received intact", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	upload.opts.Origin = mesh.Origin{Source: mesh.SourceUpload}
+	if upload.filename != "" {
+		upload.opts.Origin.Params = map[string]interface{}{"filename": upload.filename}
+	}
+	return upload, checksum, true
+}
+
+func writeStoredMesh(w http.ResponseWriter, stored *mesh.Stored, upload *meshUpload, checksum string) {
+	response := map[string]interface{}{
+		"id":                stored.MeshID,
+		"revision":          stored.Revision,
+		"representation_id": stored.RepresentationID,
+		"format":            upload.opts.Format,
+		"size":              len(upload.data),
+		"sha256":            checksum,
+		"file_url":          meshFileURL(stored.MeshID, 0, ""),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to send response: %v", err)
+	}
+}
+
+// readMeshUpload reads and validates an upload. The returned status is the
+// one to answer with when err is not nil.
+// The name is only required for a new mesh object.
+func readMeshUpload(w http.ResponseWriter, r *http.Request, requireName bool) (*meshUpload, int, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		return nil, http.StatusUnsupportedMediaType,
+			errors.New("file_path is no longer supported, upload the file as multipart/form-data or as the request body")
+	}
+
+	upload := &meshUpload{}
+	var err error
+	if mediaType == "multipart/form-data" {
+		// Leave room for the other form fields next to the file.
+		r.Body = http.MaxBytesReader(w, r.Body, maxMeshUploadSize+1<<20)
+		upload.filename, upload.data, err = readFormFile(r, "file")
+		if err == nil && int64(len(upload.data)) > maxMeshUploadSize {
+			err = &http.MaxBytesError{Limit: maxMeshUploadSize}
+		}
+		upload.name = strings.TrimSuffix(upload.filename, filepath.Ext(upload.filename))
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, maxMeshUploadSize)
+		upload.data, err = io.ReadAll(r.Body)
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("mesh file is larger than %d bytes", maxMeshUploadSize)
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("failed to read mesh file: %v", err)
+	}
+	if len(upload.data) == 0 {
+		return nil, http.StatusBadRequest, errors.New("mesh file is empty")
+	}
+
+	if name := strings.TrimSpace(r.FormValue("name")); name != "" {
+		upload.name = name
+	}
+	if upload.name == "" && requireName {
+		return nil, http.StatusBadRequest, errors.New("name is required")
+	}
+
+	upload.opts.Format, err = meshUploadFormat(r.FormValue("format"), upload.data)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if value := r.FormValue("quad"); value != "" {
+		if upload.opts.Quad, err = strconv.ParseBool(value); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid quad value %q", value)
+		}
+	}
+	if value := r.FormValue("face_limit"); value != "" {
+		if upload.opts.FaceLimit, err = strconv.Atoi(value); err != nil || upload.opts.FaceLimit < 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid face_limit value %q", value)
+		}
+	}
+
+	upload.opts.OwnerID, err = sessionUserID(r)
+	if errors.Is(err, errInvalidSession) {
+		return nil, http.StatusUnauthorized, err
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to look up session: %v", err)
+	}
+
+	upload.sha256 = r.Header.Get(ContentSHA256Header)
+	if value := r.FormValue("sha256"); value != "" {
+		upload.sha256 = value
+	}
+	return upload, http.StatusOK, nil
+}
+
+// meshUploadFormat checks the declared format against the one detected from
+// the file. Formats that cannot be detected are trusted as declared.
+func meshUploadFormat(declared string, data []byte) (string, error) {
+	declared = strings.ToUpper(strings.TrimSpace(declared))
+	detected := mesh.DetectFormat(data)
+
+	if declared == "" {
+		if detected == "" {
+			return "", errors.New("unknown mesh format, pass format explicitly")
+		}
+		return detected, nil
+	}
+
+	supported := false
+	for _, format := range mesh.UploadFormats {
+		if declared == format {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return "", fmt.Errorf("unsupported format %q, expected one of %s", declared, strings.Join(mesh.UploadFormats, ", "))
+	}
+	if detected != "" && detected != declared {
+		return "", fmt.Errorf("file looks like %s, not %s", detected, declared)
+	}
+	return declared, nil
+}
@@ -1,25 +1,53 @@
 package api
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
-	"os"
-
-	"mime/multipart"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"go-project/internal/blob"
+	"go-project/internal/database"
+	"go-project/internal/jobs"
+	"go-project/internal/localscript"
+	"go-project/internal/mesh"
+	"go-project/internal/provider"
+	"go-project/internal/webhook"
+
 	"github.com/joho/godotenv"
 )
 
+const defaultJobWorkers = 2
+
+const (
+	defaultScriptWorkers   = 1
+	defaultScriptQueueSize = 16
+	defaultScriptTimeout   = 15 * time.Minute
+)
+
+// IdempotencyKeyHeader lets clients retry a generation request without
+// starting another paid task. IdempotentReplayedHeader marks the answer to
+// such a retry.
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
 var (
-	baseURL string
-	apiKey  string
-	dest    string
+	ModelProvider   provider.Provider
+	GenerationQueue *jobs.Queue
+	Webhooks        *webhook.Dispatcher
+	ScriptPool      *localscript.Pool
+	Meshes          *mesh.Service
+	MeshCollector   *mesh.Collector
+	Blobs           blob.Store
 )
 
 func init() {
@@ -28,376 +56,367 @@ func init() {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
-	baseURL = os.Getenv("BASE_URL")
-	apiKey = os.Getenv("API_KEY")
-	dest = os.Getenv("DEST_PATH")
+	ModelProvider, err = provider.New(os.Getenv("PROVIDER"), os.Getenv("BASE_URL"), os.Getenv("API_KEY"))
+	if err != nil {
+		log.Fatalf("Error configuring model provider: %v", err)
+	}
 
-	if baseURL == "" || apiKey == "" || dest == "" {
-		log.Fatal("BASE_URL, API_KEY, or DEST_PATH not set in .env")
+	Blobs, err = blob.New(blob.ConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Error configuring blob store: %v", err)
 	}
-}
 
-type UploadResponse struct {
-	Code int `json:"code"`
-	Data struct {
-		ImageToken string `json:"image_token"`
-	} `json:"data"`
-}
+	Meshes = mesh.NewService(DbPool, Blobs)
+	maxMeshUploadSize = int64(intEnv("MAX_MESH_UPLOAD_SIZE", defaultMaxMeshUploadSize))
+	meshRestoreWindow = durationEnv("MESH_RESTORE_WINDOW", defaultMeshRestoreWindow)
+	MeshCollector = mesh.NewCollector(DbPool, Blobs, meshRestoreWindow,
+		durationEnv("MESH_GC_INTERVAL", defaultMeshGCInterval))
+	GenerationQueue = jobs.NewQueue(DbPool, ModelProvider, Meshes, Blobs, intEnv("JOB_WORKERS", defaultJobWorkers))
 
-type TaskResponse struct {
-	Code int `json:"code"`
-	Data struct {
-		TaskID string `json:"task_id"`
-	} `json:"data"`
-}
+	Webhooks = webhook.NewDispatcher(DbPool)
+	GenerationQueue.OnChange(Webhooks.JobChanged)
 
-type FinalResponse struct {
-	Code int `json:"code"`
-	Data struct {
-		TaskID          string `json:"task_id"`
-		Type            string `json:"type"`
-		Status          string `json:"status"`
-		Input           Input  `json:"input"`
-		Output          Output `json:"output"`
-		Progress        int    `json:"progress"`
-		CreateTime      int64  `json:"create_time"`
-		QueuingNum      int    `json:"queuing_num"`
-		RunningLeftTime int    `json:"running_left_time"`
-		Result          Result `json:"result"`
-	} `json:"data"`
-}
+	ScriptPool = localscript.NewPool(DbPool, Meshes, Blobs, intEnv("SCRIPT_WORKERS", defaultScriptWorkers),
+		intEnv("SCRIPT_QUEUE_SIZE", defaultScriptQueueSize), durationEnv("SCRIPT_TIMEOUT", defaultScriptTimeout))
+	GenerationQueue.SetLocalBackend(runScriptJob, ScriptPool.Cancel)
+	ScriptPool.OnFinish(finishLocalGeneration)
 
-type Input struct {
-	OriginalModelID string `json:"original_model_id"`
-	Format          string `json:"format"`
-	Quad            bool   `json:"quad"`
-	FaceLimit       int    `json:"face_limit"`
-}
+	if value := os.Getenv("GENERATION_BACKEND"); value != "" {
+		if !validBackend(value) {
+			log.Fatalf("Invalid GENERATION_BACKEND value %q", value)
+		}
+		DefaultBackend = value
+	}
 
-type Output struct {
-	Model string `json:"model"`
-}
+	sessionTTL = durationEnv("SESSION_TTL", defaultSessionTTL)
+	adminToken = os.Getenv("ADMIN_TOKEN")
 
-type Result struct {
-	Model struct {
-		Type string `json:"type"`
-		URL  string `json:"url"`
-	} `json:"model"`
+	for _, origin := range strings.Split(os.Getenv("WS_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowedOrigins = append(allowedOrigins, origin)
+		}
+	}
 }
 
-func UploadFile(w http.ResponseWriter, r *http.Request) (*UploadResponse, error) {
-	file, handler, err := r.FormFile("file")
-	if err != nil {
-		return nil, fmt.Errorf("error retrieving file: %v", err)
+// intEnv reads an integer setting, falling back to def when it is not set.
+func intEnv(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
 	}
-	defer file.Close()
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("file", handler.Filename)
+	n, err := strconv.Atoi(value)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create form file: %v", err)
+		log.Fatalf("Invalid %s value %q: %v", name, value, err)
 	}
+	return n
+}
 
-	if _, err = io.Copy(part, file); err != nil {
-		return nil, fmt.Errorf("failed to copy file content: %v", err)
+// durationEnv reads a time.ParseDuration setting, falling back to def when
+// it is not set.
+func durationEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
 	}
-	writer.Close()
-
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/upload", baseURL), body)
+	d, err := time.ParseDuration(value)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		log.Fatalf("Invalid %s value %q: %v", name, value, err)
 	}
+	if d <= 0 {
+		log.Fatalf("Invalid %s value %q: must be positive", name, value)
+	}
+	return d
+}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+// parseConversionInputs reads the optional format, quad and face_limit form
+// fields. format may be repeated or comma separated to convert the model to
+// several formats at once; quad and face_limit apply to all of them. Missing
+// fields keep their provider.DefaultConversion values.
+func parseConversionInputs(r *http.Request) ([]provider.Input, error) {
+	base := provider.DefaultConversion
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+	if value := r.FormValue("quad"); value != "" {
+		quad, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quad value %q", value)
+		}
+		base.Quad = quad
+	}
+	if value := r.FormValue("face_limit"); value != "" {
+		faceLimit, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid face_limit value %q", value)
+		}
+		base.FaceLimit = faceLimit
 	}
-	defer resp.Body.Close()
-	log.Println("firstPart: ", resp)
 
-	var uploadResp UploadResponse
-	if err := json.NewDecoder(resp.Body).Decode(&uploadResp); err != nil {
-		return nil, fmt.Errorf("failed to parse upload response: %v", err)
+	var formats []string
+	for _, value := range r.Form["format"] {
+		for _, format := range strings.Split(value, ",") {
+			if format = strings.TrimSpace(format); format != "" {
+				formats = append(formats, format)
+			}
+		}
+	}
+	if len(formats) == 0 {
+		formats = []string{base.Format}
 	}
 
-	return &uploadResp, nil
+	var conversions []provider.Input
+	seen := map[string]bool{}
+	for _, format := range formats {
+		conversion := base
+		conversion.Format = format
+		if err := conversion.Validate(); err != nil {
+			return nil, err
+		}
+		if seen[conversion.Format] {
+			continue
+		}
+		seen[conversion.Format] = true
+		conversions = append(conversions, conversion)
+	}
+	return conversions, nil
 }
 
-func CreateImageToModelTask(w http.ResponseWriter, imageToken string) (*TaskResponse, error) {
-	data := map[string]interface{}{
-		"type": "image_to_model",
-		"file": map[string]string{
-			"type":       "jpg",
-			"file_token": imageToken,
-		},
+// ProcessAll stores a generation job and returns its ID. An uploaded file
+// starts an image job; a prompt without a file starts a text job. The job is
+// picked up by the GenerationQueue workers and tracked via GET /api/jobs/{id}.
+// The job is charged to the user of the session token, which is required.
+// Images the same user generated before are served from the result cache, at
+// the cached_result price, unless force is set.
+func ProcessAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
 	}
-
-	jsonData, _ := json.Marshal(data)
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/task", baseURL), bytes.NewBuffer(jsonData))
+	req, key, err := parseGenerationRequest(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.UserID = userID
+	req.ClientID = clientID(userID)
+	if err := newCallbackSecret(&req); err != nil {
+		log.Printf("Failed to generate callback secret: %v", err)
+		http.Error(w, "Failed to create generation job", http.StatusInternalServerError)
+		return
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
-
-	log.Println("2 Part req: ", req)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	job, replayed, err := enqueueGeneration(key, req)
+	if errors.Is(err, jobs.ErrIdempotencyKeyReused) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, database.ErrInsufficientCredits) {
+		http.Error(w, "Insufficient credits", http.StatusPaymentRequired)
+		return
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		log.Printf("Failed to create generation job: %v", err)
+		http.Error(w, "Failed to create generation job", http.StatusInternalServerError)
+		return
 	}
-	defer resp.Body.Close()
-	log.Println("secondPart: ", resp)
 
-	var taskResp TaskResponse
-	if err := json.NewDecoder(resp.Body).Decode(&taskResp); err != nil {
-		return nil, fmt.Errorf("failed to parse task response: %v", err)
+	if replayed {
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		writeEnqueuedJob(w, job, http.StatusOK)
+		return
 	}
-
-	return &taskResp, nil
+	writeEnqueuedJob(w, job, http.StatusAccepted)
 }
 
-func CreateModelConversionTask(w http.ResponseWriter, taskID string) (*TaskResponse, error) {
-	data := []byte(fmt.Sprintf(`{
-		"type": "convert_model",
-		"format": "USDZ",
-		"original_model_task_id": "%s",
-		"quad": true,
-		"face_limit": 5000
-	}`, taskID))
-
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/task", baseURL), bytes.NewBuffer(data))
+// parseGenerationRequest reads a generation form and returns the request
+// together with its Idempotency-Key, if any.
+func parseGenerationRequest(r *http.Request) (jobs.Request, string, error) {
+	err := r.ParseMultipartForm(32 << 20)
+	if errors.Is(err, http.ErrNotMultipart) {
+		err = r.ParseForm()
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return jobs.Request{}, "", fmt.Errorf("Invalid form: %v", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
-
-	log.Println("3 Part req: ", req)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	conversions, err := parseConversionInputs(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		return jobs.Request{}, "", fmt.Errorf("Invalid conversion options: %v", err)
 	}
-	defer resp.Body.Close()
-	log.Println("3 Part resp: ", resp)
 
-	var convResp TaskResponse
-	if err := json.NewDecoder(resp.Body).Decode(&convResp); err != nil {
-		return nil, fmt.Errorf("failed to parse conversion task response: %v", err)
+	req, err := parseGenerationSource(r)
+	if err != nil {
+		return jobs.Request{}, "", err
 	}
 
-	return &convResp, nil
-}
-
-func PollTask(w http.ResponseWriter, taskID string) (string, error) {
-	client := &http.Client{}
-	for {
-		req, err := http.NewRequest("GET", fmt.Sprintf("%s/task/%s", baseURL, taskID), nil)
-		if err != nil {
-			return "", fmt.Errorf("failed to create request: %v", err)
-		}
-
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
-		resp, err := client.Do(req)
-		if err != nil {
-			return "", fmt.Errorf("failed to send request: %v", err)
+	req.Conversions = conversions
+	if value := r.FormValue("mesh_id"); value != "" {
+		req.TargetMeshID, err = strconv.Atoi(value)
+		if err != nil || req.TargetMeshID <= 0 {
+			return jobs.Request{}, "", fmt.Errorf("Invalid mesh_id value %q", value)
 		}
-		defer resp.Body.Close()
-		log.Println("4 Part: ", resp)
-
-		var finalResp FinalResponse
-		if err := json.NewDecoder(resp.Body).Decode(&finalResp); err != nil {
-			return "", fmt.Errorf("failed to parse response: %v", err)
+		exists, err := database.MeshObjectExists(DbPool, req.TargetMeshID)
+		if err != nil || !exists {
+			return jobs.Request{}, "", fmt.Errorf("Mesh object %d not found", req.TargetMeshID)
 		}
-
-		log.Println("4 Part status", finalResp.Data.Status)
-		if finalResp.Data.Status == "success" {
-			return finalResp.Data.Result.Model.URL, nil
+	}
+	if value := r.FormValue("force"); value != "" {
+		req.Force, err = strconv.ParseBool(value)
+		if err != nil {
+			return jobs.Request{}, "", fmt.Errorf("Invalid force value %q", value)
 		}
-
-		time.Sleep(5 * time.Second)
 	}
-}
-
-func PollTaskComplite(w http.ResponseWriter, taskID string) (string, error) {
-        client := &http.Client{}
-        for {
-                req, err := http.NewRequest("GET", fmt.Sprintf("%s/task/%s", baseURL, taskID), nil)
-                if err != nil {
-                        return "", fmt.Errorf("failed to create request: %v", err)
-                }
-
-                req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
-                resp, err := client.Do(req)
-                if err != nil {
-                        return "", fmt.Errorf("failed to send request: %v", err)
-                }
-                defer resp.Body.Close()
-                log.Println("2.5 Part: ", resp)
-
-                var finalResp FinalResponse
-                if err := json.NewDecoder(resp.Body).Decode(&finalResp); err != nil {
-                        return "", fmt.Errorf("failed to parse response: %v", err)
-                }
-
-                log.Println("2.5 Part status", finalResp.Data.Status)
-                if finalResp.Data.Status == "success" {
-                        return finalResp.Data.TaskID, nil
-                }
-
-                time.Sleep(5 * time.Second)
-        }
-}
-
-
-func downloadFile(url string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to get the file: %w", err)
+	req.CallbackURL = r.FormValue("callback_url")
+	if req.CallbackURL != "" {
+		if err := webhook.ValidateURL(req.CallbackURL); err != nil {
+			return jobs.Request{}, "", fmt.Errorf("Invalid callback_url: %v", err)
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		return jobs.Request{}, "", fmt.Errorf("%s must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength)
 	}
+	return req, key, nil
+}
 
-	outFile, err := os.Create(dest)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+func newCallbackSecret(req *jobs.Request) error {
+	if req.CallbackURL == "" {
+		return nil
 	}
-	defer outFile.Close()
-
-	_, err = io.Copy(outFile, resp.Body)
+	secret, err := webhook.NewSecret()
 	if err != nil {
-		return fmt.Errorf("failed to copy the file content: %w", err)
+		return err
 	}
-
-	fmt.Println("File downloaded successfully!")
+	req.CallbackSecret = secret
 	return nil
 }
 
-func ProcessAll(w http.ResponseWriter, r *http.Request) {
-	log.Println("1 Part started")
-	uploadResp, err := UploadFile(w, r)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to upload file: %v", err), http.StatusInternalServerError)
-		return
+// enqueueGeneration creates the job of a request. With an Idempotency-Key a
+// repeated request gets the job created by the first one, with replayed set.
+func enqueueGeneration(key string, req jobs.Request) (*database.GenerationJob, bool, error) {
+	if key == "" {
+		job, err := GenerationQueue.Enqueue(req)
+		if err != nil {
+			return nil, false, err
+		}
+		log.Printf("Created %s generation job %d", req.Mode, job.ID)
+		return job, false, nil
 	}
-	log.Println("1 Part finished")
 
-	log.Println("2 Part started")
-	taskResp, err := CreateImageToModelTask(w, uploadResp.Data.ImageToken)
+	job, replayed, err := GenerationQueue.EnqueueIdempotent(key, req)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create image-to-model task: %v", err), http.StatusInternalServerError)
-		return
+		return nil, false, err
 	}
-	log.Println("2 Part finished")
-
-        log.Println("2.5 Part started")
-	taskID, err := PollTaskComplite(w, taskResp.Data.TaskID)
-        if err != nil {
-                http.Error(w, fmt.Sprintf("Failed to poll task: %v", err), http.StatusInternalServerError)
-                return
-        }
-        log.Println("2.5 Part finished")
-
-	log.Println("3 Part started")
-	convResp, err := CreateModelConversionTask(w, taskID)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create model conversion task: %v", err), http.StatusInternalServerError)
-		return
+	if replayed {
+		log.Printf("Replayed generation job %d for idempotency key %q", job.ID, key)
+	} else {
+		log.Printf("Created %s generation job %d for idempotency key %q", req.Mode, job.ID, key)
 	}
-	log.Println("3 Part finished")
+	return job, replayed, nil
+}
 
-	log.Println("4 Part started")
-	modelURL, err := PollTask(w, convResp.Data.TaskID)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to poll task: %v", err), http.StatusInternalServerError)
-		return
+// writeEnqueuedJob answers a generation request. A job served from the result
+// cache has already succeeded and carries the mesh ID.
+func writeEnqueuedJob(w http.ResponseWriter, job *database.GenerationJob, statusCode int) {
+	response := map[string]interface{}{"job_id": job.ID, "status": job.Status}
+	if job.MeshID != 0 {
+		response["mesh_id"] = job.MeshID
 	}
-	log.Println("4 Part finished")
-
-	log.Println("5 Part started")
-	err = downloadFile(modelURL)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to download file: %v", err), http.StatusInternalServerError)
-		return
+	if job.CachedFromJobID != 0 {
+		response["cached_from_job_id"] = job.CachedFromJobID
 	}
-	log.Println("5 Part finished")
-
-	saveData := SaveRequestData{FilePath: dest, Name: "GeneratedObject"}
-	saveDataBytes, _ := json.Marshal(saveData)
-
-	resp, err := http.Post("http://90.156.217.78:8080/api/mesh", "application/json", bytes.NewBuffer(saveDataBytes))
-	if err != nil {
-		log.Printf("Failed to send save mesh request: %v", err)
-		http.Error(w, "Failed to save mesh object 1 part", http.StatusInternalServerError)
-		return
+	if job.CallbackSecret != "" {
+		response["callback_secret"] = job.CallbackSecret
 	}
-	defer resp.Body.Close()
-	log.Printf("Received response from save mesh API with status: %d", resp.StatusCode)
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Save mesh API returned an error: %d", resp.StatusCode)
-		http.Error(w, "Failed to save mesh object impossible", resp.StatusCode)
-		return
-	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
 
-	var saveResponse map[string]int
-	if err := json.NewDecoder(resp.Body).Decode(&saveResponse); err != nil {
-		log.Printf("Failed to decode save response: %v", err)
-		http.Error(w, "Failed to decode save response", http.StatusInternalServerError)
-		return
+// parseGenerationSource reads what the model is generated from: the uploaded
+// file, one file per view field (front, left, back, right), or the prompt and
+// negative_prompt fields. mode selects one explicitly when several are sent.
+func parseGenerationSource(r *http.Request) (jobs.Request, error) {
+	prompt := strings.TrimSpace(r.FormValue("prompt"))
+	negativePrompt := strings.TrimSpace(r.FormValue("negative_prompt"))
+
+	mode := r.FormValue("mode")
+	if mode == "" {
+		switch {
+		case hasFormFile(r, "file"):
+			mode = jobs.ModeImage
+		case hasFormFile(r, provider.Views...):
+			mode = jobs.ModeMultiview
+		case prompt != "":
+			mode = jobs.ModeText
+		default:
+			mode = jobs.ModeImage
+		}
 	}
 
-	getMeshURL := fmt.Sprintf("http://90.156.217.78:8080/api/mesh/%d", saveResponse["id"])
-	log.Printf("Fetching saved mesh data from URL: %s", getMeshURL)
+	switch mode {
+	case jobs.ModeText:
+		if prompt == "" {
+			return jobs.Request{}, fmt.Errorf("prompt is required")
+		}
+		if len(prompt) > provider.MaxPromptLength {
+			return jobs.Request{}, fmt.Errorf("prompt must be at most %d characters", provider.MaxPromptLength)
+		}
+		if len(negativePrompt) > provider.MaxNegativePromptLength {
+			return jobs.Request{}, fmt.Errorf("negative_prompt must be at most %d characters", provider.MaxNegativePromptLength)
+		}
+		return jobs.Request{Mode: mode, Prompt: prompt, NegativePrompt: negativePrompt}, nil
 
-	meshResp, err := http.Get(getMeshURL)
-	if err != nil {
-		log.Printf("Failed to fetch saved mesh data: %v", err)
-		http.Error(w, "Failed to retrieve saved object", http.StatusInternalServerError)
-		return
+	case jobs.ModeImage:
+		filename, image, err := readFormFile(r, "file")
+		if err != nil {
+			return jobs.Request{}, err
+		}
+		return jobs.Request{Mode: mode, Filename: filename, Image: image}, nil
+
+	case jobs.ModeMultiview:
+		req := jobs.Request{Mode: mode}
+		for _, view := range provider.Views {
+			if !hasFormFile(r, view) {
+				continue
+			}
+			filename, image, err := readFormFile(r, view)
+			if err != nil {
+				return jobs.Request{}, err
+			}
+			req.Images = append(req.Images, jobs.Image{View: view, Filename: filename, Data: image})
+		}
+		if !hasFormFile(r, provider.Views[0]) {
+			return jobs.Request{}, fmt.Errorf("%s view is required", provider.Views[0])
+		}
+		req.Filename = req.Images[0].Filename
+		return req, nil
 	}
-	defer meshResp.Body.Close()
+	return jobs.Request{}, fmt.Errorf("unsupported mode %q", mode)
+}
 
-	if meshResp.StatusCode != http.StatusOK {
-		log.Printf("Error fetching saved mesh object: %d", meshResp.StatusCode)
-		http.Error(w, "Failed to retrieve object details", meshResp.StatusCode)
-		return
+func hasFormFile(r *http.Request, fields ...string) bool {
+	if r.MultipartForm == nil {
+		return false
 	}
-
-	meshData, err := ioutil.ReadAll(meshResp.Body)
-	if err != nil {
-		log.Printf("Failed to read mesh data: %v", err)
-		http.Error(w, "Failed to read mesh data", http.StatusInternalServerError)
-		return
+	for _, field := range fields {
+		if len(r.MultipartForm.File[field]) > 0 {
+			return true
+		}
 	}
-	log.Println("Successfully read mesh data")
+	return false
+}
 
-	var meshJSON json.RawMessage
-	if err := json.Unmarshal(meshData, &meshJSON); err != nil {
-		log.Printf("Failed to parse mesh data: %v", err)
-		http.Error(w, "Failed to parse mesh data", http.StatusInternalServerError)
-		return
+func readFormFile(r *http.Request, field string) (string, []byte, error) {
+	file, handler, err := r.FormFile(field)
+	if err != nil {
+		return "", nil, fmt.Errorf("error retrieving %s file: %w", field, err)
 	}
-	log.Println("Successfully parsed mesh data")
+	defer file.Close()
 
-	response := ResponseData{
-		MeshData:    meshJSON,
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s file: %v", field, err)
 	}
-	responseBytes, _ := json.Marshal(response)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(responseBytes)
+	return handler.Filename, data, nil
 }
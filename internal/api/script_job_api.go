@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-project/internal/blob"
+	"go-project/internal/database"
+	"go-project/internal/localscript"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+type ScriptJobResponse struct {
+	ID              int        `json:"id"`
+	Status          string     `json:"status"`
+	Filename        string     `json:"filename"`
+	GenerationJobID int        `json:"generation_job_id,omitempty"`
+	QueuePosition   int        `json:"queue_position,omitempty"`
+	QueueDepth      int        `json:"queue_depth"`
+	ExitCode        *int       `json:"exit_code,omitempty"`
+	Stdout          string     `json:"stdout"`
+	Stderr          string     `json:"stderr"`
+	MeshID          int        `json:"mesh_id,omitempty"`
+	PhotoBase64     string     `json:"photo_base64,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+}
+
+// GetScriptJobHandler returns the state of a local script run of the session
+// user. Queued jobs report their position in the queue, starting at 1.
+func GetScriptJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := database.GetScriptJobByID(DbPool, id)
+	if err == nil && job.OwnerID != userID {
+		err = pgx.ErrNoRows
+	}
+	if err != nil {
+		log.Printf("Failed to fetch script job %d: %v", id, err)
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	response := ScriptJobResponse{
+		ID:              job.ID,
+		Status:          job.Status,
+		Filename:        job.Filename,
+		GenerationJobID: job.GenerationJobID,
+		QueueDepth:      ScriptPool.QueueDepth(),
+		ExitCode:        job.ExitCode,
+		Stdout:          job.Stdout,
+		Stderr:          job.Stderr,
+		MeshID:          job.MeshID,
+		Error:           job.Error,
+		CreatedAt:       job.CreatedAt,
+		StartedAt:       job.StartedAt,
+		FinishedAt:      job.FinishedAt,
+	}
+	photo := job.Photo
+	if job.PhotoRef.Key != "" {
+		photo, err = blob.Load(r.Context(), Blobs, job.PhotoRef)
+		if err != nil {
+			log.Printf("Failed to load photo of script job %d: %v", id, err)
+		}
+	}
+	if photo != nil {
+		response.PhotoBase64 = base64.StdEncoding.EncodeToString(photo)
+	}
+	if job.Status == localscript.StatusQueued {
+		ahead, err := database.CountScriptJobsAhead(DbPool, id)
+		if err != nil {
+			log.Printf("Failed to fetch queue position of script job %d: %v", id, err)
+		} else {
+			response.QueuePosition = ahead + 1
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to send response: %v", err)
+	}
+}
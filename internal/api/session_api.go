@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"go-project/internal/database"
+	"go-project/internal/session"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Login returns a session token that is sent as "Authorization: Bearer
+// <token>". It is valid for SESSION_TTL.
+const defaultSessionTTL = 30 * 24 * time.Hour
+
+var (
+	sessionTTL = defaultSessionTTL
+	adminToken string
+)
+
+// errInvalidSession is returned for an Authorization header that does not
+// name a live session.
+var errInvalidSession = errors.New("invalid or expired session token")
+
+// newSession stores a session of the user and returns its token.
+func newSession(userID int) (string, time.Time, error) {
+	token, err := session.NewToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(sessionTTL)
+	if err := database.CreateUserSession(DbPool, userID, session.Hash(token), expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// sessionUserID returns the user of the session token in the Authorization
+// header, or 0 for anonymous requests that do not send one.
+func sessionUserID(r *http.Request) (int, error) {
+	token, ok := session.BearerToken(r)
+	if !ok {
+		return 0, nil
+	}
+	userID, err := database.GetSessionUserID(DbPool, session.Hash(token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errInvalidSession
+	}
+	return userID, err
+}
+
+// requestUserID is sessionUserID for handlers: it answers 401 or 500 itself
+// and returns false when the request must stop.
+func requestUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := sessionUserID(r)
+	if errors.Is(err, errInvalidSession) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return 0, false
+	}
+	if err != nil {
+		log.Printf("Failed to look up session: %v", err)
+		http.Error(w, "Failed to look up session", http.StatusInternalServerError)
+		return 0, false
+	}
+	return userID, true
+}
+
+// requireUserID is requestUserID for routes that need a user, such as those
+// that cost credits: anonymous requests get 401 too.
+func requireUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, ok := requestUserID(w, r)
+	if ok && userID == 0 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "A session token is required", http.StatusUnauthorized)
+		return 0, false
+	}
+	return userID, ok
+}
+
+// RequireAdmin lets a request through only when it carries ADMIN_TOKEN in
+// the X-Admin-Token header. Without ADMIN_TOKEN the route is disabled.
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session.RequireAdmin(adminToken, next)(w, r)
+	}
+}
+
+func isAdmin(r *http.Request) bool {
+	return session.IsAdmin(r, adminToken)
+}
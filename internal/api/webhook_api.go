@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-project/internal/database"
+	"go-project/internal/webhook"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+type WebhookSubscriptionRequest struct {
+	URL string `json:"url"`
+}
+
+type WebhookSubscriptionResponse struct {
+	ID       int    `json:"id"`
+	ClientID string `json:"client_id"`
+	URL      string `json:"url"`
+	Secret   string `json:"secret"`
+}
+
+type WebhookDeliveryResponse struct {
+	ID         int       `json:"id"`
+	URL        string    `json:"url"`
+	Event      string    `json:"event"`
+	Payload    string    `json:"payload"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+	Delivered  bool      `json:"delivered"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// clientID is the webhook client of a user. The jobs of the user are
+// reported to its webhook and its Idempotency-Keys are scoped to it.
+func clientID(userID int) string {
+	return fmt.Sprintf("user-%d", userID)
+}
+
+// RegisterWebhookHandler sets the callback URL of the session user. The
+// returned secret signs every payload, see webhook.Sign. Registering again
+// replaces the URL and rotates the secret.
+func RegisterWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	var req WebhookSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if err := webhook.ValidateURL(req.URL); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid url: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		log.Printf("Failed to generate webhook secret: %v", err)
+		http.Error(w, "Failed to register webhook", http.StatusInternalServerError)
+		return
+	}
+
+	client := clientID(userID)
+	id, err := database.CreateWebhookSubscription(DbPool, client, req.URL, secret)
+	if errors.Is(err, pgx.ErrNoRows) {
+		id, err = replaceWebhookSubscription(client, req.URL, secret)
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "The webhook was replaced concurrently, try again", http.StatusConflict)
+			return
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to save webhook subscription: %v", err)
+		http.Error(w, "Failed to register webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(WebhookSubscriptionResponse{
+		ID:       id,
+		ClientID: client,
+		URL:      req.URL,
+		Secret:   secret,
+	})
+}
+
+// replaceWebhookSubscription updates the subscription of client. It returns
+// pgx.ErrNoRows when another request replaced it in the meantime.
+func replaceWebhookSubscription(client string, url string, secret string) (int, error) {
+	sub, err := database.GetWebhookSubscriptionByClientID(DbPool, client)
+	if err != nil {
+		return 0, err
+	}
+	return database.ReplaceWebhookSubscription(DbPool, client, sub.Secret, url, secret)
+}
+
+// GetWebhookDeliveriesHandler lists the webhook deliveries of a job of the
+// session user.
+func GetWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	if _, ok := userJob(w, r, id); !ok {
+		return
+	}
+
+	deliveries, err := database.ListWebhookDeliveries(DbPool, id)
+	if err != nil {
+		log.Printf("Failed to fetch webhook deliveries of job %d: %v", id, err)
+		http.Error(w, "Failed to fetch webhook deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]WebhookDeliveryResponse, 0, len(deliveries))
+	for _, d := range deliveries {
+		response = append(response, WebhookDeliveryResponse{
+			ID:         d.ID,
+			URL:        d.URL,
+			Event:      d.Event,
+			Payload:    d.Payload,
+			Attempt:    d.Attempt,
+			StatusCode: d.StatusCode,
+			Error:      d.Error,
+			Delivered:  d.Delivered,
+			CreatedAt:  d.CreatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
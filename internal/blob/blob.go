@@ -0,0 +1,129 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var (
+	ErrNotFound         = errors.New("blob not found")
+	ErrChecksumMismatch = errors.New("blob checksum mismatch")
+)
+
+// Store keeps file contents outside of Postgres. Keys are chosen by the
+// caller; Save uses the SHA-256 of the content so equal files are stored once.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every blob in the store, in no particular order.
+	List(ctx context.Context, fn func(Info) error) error
+}
+
+// Info describes a stored blob. ModTime is the last time it was written,
+// including a Put of content that was already stored.
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Ref is what a database row keeps instead of the file itself.
+type Ref struct {
+	Key    string
+	Size   int
+	SHA256 string
+}
+
+// Save stores data under its SHA-256 and returns the reference to keep in
+// the database.
+func Save(ctx context.Context, s Store, data []byte) (Ref, error) {
+	sum := sha256.Sum256(data)
+	ref := Ref{Key: hex.EncodeToString(sum[:]), Size: len(data)}
+	ref.SHA256 = ref.Key
+
+	if err := s.Put(ctx, ref.Key, data); err != nil {
+		return Ref{}, fmt.Errorf("failed to store blob %s: %v", ref.Key, err)
+	}
+	return ref, nil
+}
+
+// Load reads the blob of ref and checks it against the stored checksum.
+func Load(ctx context.Context, s Store, ref Ref) ([]byte, error) {
+	data, err := s.Get(ctx, ref.Key)
+	if err != nil {
+		return nil, err
+	}
+	if ref.SHA256 != "" {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != ref.SHA256 {
+			return nil, fmt.Errorf("%w: %s", ErrChecksumMismatch, ref.Key)
+		}
+	}
+	return data, nil
+}
+
+// Config selects and configures a Store. Backend is "fs" (the default) or
+// "s3".
+type Config struct {
+	Backend string
+	Dir     string
+
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// ConfigFromEnv reads the BLOB_* and S3_* settings.
+func ConfigFromEnv() Config {
+	return Config{
+		Backend:   os.Getenv("BLOB_STORE"),
+		Dir:       os.Getenv("BLOB_DIR"),
+		Endpoint:  os.Getenv("S3_ENDPOINT"),
+		Bucket:    os.Getenv("S3_BUCKET"),
+		Region:    os.Getenv("S3_REGION"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+	}
+}
+
+const defaultDir = "blobs"
+
+func New(cfg Config) (Store, error) {
+	switch cfg.Backend {
+	case "", "fs":
+		dir := cfg.Dir
+		if dir == "" {
+			dir = defaultDir
+		}
+		return NewFS(dir)
+	case "s3":
+		if cfg.Endpoint == "" || cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+			return nil, fmt.Errorf("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY are required for the s3 blob store")
+		}
+		return NewS3(cfg.Endpoint, cfg.Bucket, cfg.Region, cfg.AccessKey, cfg.SecretKey)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.Backend)
+	}
+}
+
+// validKey only allows the lowercase hex keys produced by Save, so a key read
+// from the database can never point outside the store.
+func validKey(key string) error {
+	if len(key) < 4 {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, c := range key {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
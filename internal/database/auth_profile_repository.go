@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 
+	"go-project/internal/blob"
+
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -21,12 +24,16 @@ type Profile struct {
 	Bio        string
 }
 
+// Item is a catalog item. The 3D object and the photo live in the blob
+// store; Object3D and Photo are only filled for rows not moved there yet.
 type Item struct {
-	ID         int
-	CatalogID  int
-	Name       string
-	Object3D   []byte
-	Photo      []byte
+	ID          int
+	CatalogID   int
+	Name        string
+	Object3DRef blob.Ref
+	PhotoRef    blob.Ref
+	Object3D    []byte
+	Photo       []byte
 }
 
 func CreateUser(db *pgxpool.Pool, nickname string, password string) (int, error) {
@@ -66,13 +73,8 @@ func GetUserByNickName(db *pgxpool.Pool, nickname string) (*User, error) {
 	return &user, nil
 }
 
-func DeleteUserByID(db *pgxpool.Pool, id int) (error) {
-	query := `DELETE FROM users WHERE id = $1`
-	err := db.QueryRow(context.Background(), query).Scan(&id)
-	if err != nil {
-		return err
-	}
-	return nil
+func DeleteUserByID(db *pgxpool.Pool, id int) error {
+	return deleteByID(db, `DELETE FROM users WHERE id = $1`, id)
 }
 
 func UserExists(db *pgxpool.Pool, user_id int) (bool, error) {
@@ -112,19 +114,17 @@ func GetProfileByID(db *pgxpool.Pool, id int) (*Profile, error) {
 	return &profile, nil
 }
 
-func DeleteProfileВyID(db *pgxpool.Pool, id int) (error) {
-	query := `DELETE FROM profile WHERE id = $1`
-	err := db.QueryRow(context.Background(), query).Scan(&id)
-	if err != nil {
-		return err
-	}
-	return nil
+func DeleteProfileByID(db *pgxpool.Pool, id int) error {
+	return deleteByID(db, `DELETE FROM profiles WHERE id = $1`, id)
 }
 
-func CreateItem(db *pgxpool.Pool, catalog_id int, name string, object_3d []byte, photo []byte) (int, error) {
+func CreateItem(db *pgxpool.Pool, catalog_id int, name string, object_3d blob.Ref, photo blob.Ref) (int, error) {
 	var id int
-	query := `INSERT INTO items (catalog_id, name, object_3d, photo) VALUES ($1, $2, $3, $4) RETURNING id`
-	err := db.QueryRow(context.Background(), query, catalog_id, name, object_3d, photo).Scan(&id)
+	query := `INSERT INTO items (catalog_id, name, object_3d_key, object_3d_size, object_3d_sha256,
+			photo_key, photo_size, photo_sha256)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, NULLIF($6, ''), $7, $8) RETURNING id`
+	err := db.QueryRow(context.Background(), query, catalog_id, name, object_3d.Key, object_3d.Size, object_3d.SHA256,
+		photo.Key, photo.Size, photo.SHA256).Scan(&id)
 	if err != nil {
 		return 0, err
 	}
@@ -132,11 +132,16 @@ func CreateItem(db *pgxpool.Pool, catalog_id int, name string, object_3d []byte,
 }
 
 func GetItemByID(db *pgxpool.Pool, id int) (*Item, error) {
-	query := `SELECT id, catalog_id, name, object_3d, photo WHERE id = $1`
+	query := `SELECT id, catalog_id, name,
+			COALESCE(object_3d_key, ''), object_3d_size, object_3d_sha256, CASE WHEN object_3d_key IS NULL THEN object_3d END,
+			COALESCE(photo_key, ''), photo_size, photo_sha256, CASE WHEN photo_key IS NULL THEN photo END
+		FROM items WHERE id = $1`
 	row := db.QueryRow(context.Background(), query, id)
 
 	var item Item
-	err := row.Scan(&item.ID, &item.CatalogID, &item.Name, &item.Object3D, &item.Photo)
+	err := row.Scan(&item.ID, &item.CatalogID, &item.Name,
+		&item.Object3DRef.Key, &item.Object3DRef.Size, &item.Object3DRef.SHA256, &item.Object3D,
+		&item.PhotoRef.Key, &item.PhotoRef.Size, &item.PhotoRef.SHA256, &item.Photo)
 	if err != nil {
 		return nil, err
 	}
@@ -144,11 +149,21 @@ func GetItemByID(db *pgxpool.Pool, id int) (*Item, error) {
 	return &item, nil
 }
 
-func DeleteItemyID(db *pgxpool.Pool, id int) (error) {
-	query := `DELETE FROM item WHERE id = $1`
-	err := db.QueryRow(context.Background(), query).Scan(&id)
+// DeleteItemByID removes an item. Its files stay in the blob store until the
+// mesh collector finds them unreferenced.
+func DeleteItemByID(db *pgxpool.Pool, id int) error {
+	return deleteByID(db, `DELETE FROM items WHERE id = $1`, id)
+}
+
+// deleteByID runs a DELETE of one row and returns pgx.ErrNoRows when there
+// was no row with that ID.
+func deleteByID(db *pgxpool.Pool, query string, id int) error {
+	tag, err := db.Exec(context.Background(), query, id)
 	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
 	return nil
 }
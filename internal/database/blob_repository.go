@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"go-project/internal/blob"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// legacyBlobColumn is a bytea column whose contents move to the blob store,
+// with the columns that keep the reference instead.
+type legacyBlobColumn struct {
+	table  string
+	data   string
+	key    string
+	size   string
+	sha256 string
+}
+
+var legacyBlobColumns = []legacyBlobColumn{
+	{"mesh_objects", "data", "blob_key", "blob_size", "blob_sha256"},
+	{"mesh_representations", "data", "blob_key", "blob_size", "blob_sha256"},
+	{"items", "object_3d", "object_3d_key", "object_3d_size", "object_3d_sha256"},
+	{"items", "photo", "photo_key", "photo_size", "photo_sha256"},
+	{"generations", "model", "model_key", "model_size", "model_sha256"},
+	{"generation_jobs", "source_image", "source_image_key", "source_image_size", "source_image_sha256"},
+	{"job_images", "data", "data_key", "data_size", "data_sha256"},
+	{"script_jobs", "source_image", "source_image_key", "source_image_size", "source_image_sha256"},
+	{"script_jobs", "photo", "photo_key", "photo_size", "photo_sha256"},
+}
+
+// LegacyBlob is file content still stored in a bytea column.
+type LegacyBlob struct {
+	Table  string
+	Column string
+	ID     int
+	Data   []byte
+
+	column legacyBlobColumn
+}
+
+// CountLegacyBlobs returns how many values are still stored in Postgres,
+// keyed by "table.column".
+func CountLegacyBlobs(db *pgxpool.Pool) (map[string]int, error) {
+	counts := make(map[string]int)
+	for _, c := range legacyBlobColumns {
+		var n int
+		query := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s IS NULL AND %s IS NOT NULL`, c.table, c.key, c.data)
+		if err := db.QueryRow(context.Background(), query).Scan(&n); err != nil {
+			return nil, fmt.Errorf("failed to count %s.%s: %v", c.table, c.data, err)
+		}
+		counts[c.table+"."+c.data] = n
+	}
+	return counts, nil
+}
+
+// ListLegacyBlobs returns up to limit values still stored in Postgres, all
+// from the same column.
+func ListLegacyBlobs(db *pgxpool.Pool, limit int) ([]LegacyBlob, error) {
+	for _, c := range legacyBlobColumns {
+		query := fmt.Sprintf(`SELECT id, %s FROM %s WHERE %s IS NULL AND %s IS NOT NULL ORDER BY id LIMIT $1`,
+			c.data, c.table, c.key, c.data)
+		rows, err := db.Query(context.Background(), query, limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s.%s: %v", c.table, c.data, err)
+		}
+
+		var blobs []LegacyBlob
+		for rows.Next() {
+			b := LegacyBlob{Table: c.table, Column: c.data, column: c}
+			if err := rows.Scan(&b.ID, &b.Data); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			blobs = append(blobs, b)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if len(blobs) > 0 {
+			return blobs, nil
+		}
+	}
+	return nil, nil
+}
+
+// MoveLegacyBlob points the row of b at ref and drops the bytea value. The
+// blob must already be in the store.
+func MoveLegacyBlob(db *pgxpool.Pool, b LegacyBlob, ref blob.Ref) error {
+	c := b.column
+	query := fmt.Sprintf(`UPDATE %s SET %s = $2, %s = $3, %s = $4, %s = NULL WHERE id = $1 AND %s IS NULL`,
+		c.table, c.key, c.size, c.sha256, c.data, c.key)
+	_, err := db.Exec(context.Background(), query, b.ID, ref.Key, ref.Size, ref.SHA256)
+	return err
+}
+
+// ListBlobKeys returns every blob key referenced from the database,
+// including by deleted meshes that have not been purged yet.
+func ListBlobKeys(db *pgxpool.Pool) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	for _, c := range legacyBlobColumns {
+		query := fmt.Sprintf(`SELECT DISTINCT %s FROM %s WHERE %s IS NOT NULL`, c.key, c.table, c.key)
+		rows, err := db.Query(context.Background(), query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s.%s: %v", c.table, c.key, err)
+		}
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			keys[key] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
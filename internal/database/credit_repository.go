@@ -0,0 +1,229 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	CreditGrant   = "grant"
+	CreditReserve = "reserve"
+	CreditSettle  = "settle"
+	CreditRefund  = "refund"
+)
+
+// Credit states of a generation job.
+const (
+	CreditsReserved = "reserved"
+	CreditsSettled  = "settled"
+	CreditsRefunded = "refunded"
+)
+
+var ErrInsufficientCredits = errors.New("insufficient credits")
+
+// CreditTransaction is one ledger entry. Amount is negative when credits are
+// taken from the user; Balance is the balance after the entry.
+type CreditTransaction struct {
+	ID          int
+	UserID      int
+	JobID       int
+	Kind        string
+	Amount      int
+	Balance     int
+	Description string
+	CreatedAt   time.Time
+}
+
+// GetCreditCosts returns the cost of every provider task type.
+func GetCreditCosts(db *pgxpool.Pool) (map[string]int, error) {
+	rows, err := db.Query(context.Background(), `SELECT task_type, cost FROM credit_costs`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	costs := map[string]int{}
+	for rows.Next() {
+		var taskType string
+		var cost int
+		if err := rows.Scan(&taskType, &cost); err != nil {
+			return nil, err
+		}
+		costs[taskType] = cost
+	}
+	return costs, rows.Err()
+}
+
+func GetCreditBalance(db *pgxpool.Pool, userID int) (int, error) {
+	var balance int
+	query := `SELECT COALESCE((SELECT balance FROM credit_balances WHERE user_id = $1), 0)`
+	err := db.QueryRow(context.Background(), query, userID).Scan(&balance)
+	if err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+// GrantCredits adds amount credits to the balance of a user.
+func GrantCredits(db *pgxpool.Pool, userID int, amount int, description string) (*CreditTransaction, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO credit_balances (user_id, balance) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET balance = credit_balances.balance + EXCLUDED.balance, updated_at = NOW()`
+	if _, err := tx.Exec(ctx, query, userID, amount); err != nil {
+		return nil, err
+	}
+	t, err := addCreditTransaction(ctx, tx, userID, 0, CreditGrant, amount, description)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ListCreditTransactions returns the ledger of a user, newest first.
+func ListCreditTransactions(db *pgxpool.Pool, userID int, limit int) ([]CreditTransaction, error) {
+	query := `SELECT id, user_id, COALESCE(job_id, 0), kind, amount, balance, description, created_at
+		FROM credit_transactions WHERE user_id = $1 ORDER BY id DESC LIMIT $2`
+	rows, err := db.Query(context.Background(), query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []CreditTransaction
+	for rows.Next() {
+		var t CreditTransaction
+		err := rows.Scan(&t.ID, &t.UserID, &t.JobID, &t.Kind, &t.Amount, &t.Balance, &t.Description, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, rows.Err()
+}
+
+// reserveCredits takes the credits of a new job from the user's balance. It
+// fails with ErrInsufficientCredits when the balance is too low.
+func reserveCredits(ctx context.Context, tx pgx.Tx, job *GenerationJob) error {
+	query := `UPDATE credit_balances SET balance = balance - $2, updated_at = NOW()
+		WHERE user_id = $1 AND balance >= $2`
+	tag, err := tx.Exec(ctx, query, job.UserID, job.CreditsReserved)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrInsufficientCredits
+	}
+
+	description := fmt.Sprintf("reserved for %s generation", job.Mode)
+	if job.CachedFromJobID != 0 {
+		description = fmt.Sprintf("reserved for cached result of job %d", job.CachedFromJobID)
+	}
+	_, err = addCreditTransaction(ctx, tx, job.UserID, job.ID, CreditReserve, -job.CreditsReserved, description)
+	return err
+}
+
+// FinishJobCredits settles the credits reserved for a succeeded job, or
+// refunds them for a failed or cancelled one. Jobs without reserved credits
+// and jobs that were already settled or refunded are left alone.
+func FinishJobCredits(db *pgxpool.Pool, jobID int, succeeded bool) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	state, kind := CreditsRefunded, CreditRefund
+	if succeeded {
+		state, kind = CreditsSettled, CreditSettle
+	}
+
+	var userID, reserved int
+	query := `UPDATE generation_jobs SET credits_state = $2
+		WHERE id = $1 AND credits_state = 'reserved'
+		RETURNING user_id, credits_reserved`
+	err = tx.QueryRow(ctx, query, jobID, state).Scan(&userID, &reserved)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	amount := 0
+	description := fmt.Sprintf("charged %d credits", reserved)
+	if !succeeded {
+		amount = reserved
+		description = fmt.Sprintf("refunded %d credits", reserved)
+		query := `UPDATE credit_balances SET balance = balance + $2, updated_at = NOW() WHERE user_id = $1`
+		if _, err := tx.Exec(ctx, query, userID, amount); err != nil {
+			return err
+		}
+	}
+	if _, err := addCreditTransaction(ctx, tx, userID, jobID, kind, amount, description); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ReduceJobCredits lowers the reservation of a job to credits and refunds
+// the difference, for a job handed over to a cheaper backend. Jobs that
+// reserved no more than that are left alone.
+func ReduceJobCredits(db *pgxpool.Pool, jobID int, credits int, description string) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID, reserved int
+	query := `SELECT user_id, credits_reserved FROM generation_jobs
+		WHERE id = $1 AND credits_state = 'reserved' AND credits_reserved > $2 FOR UPDATE`
+	err = tx.QueryRow(ctx, query, jobID, credits).Scan(&userID, &reserved)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE generation_jobs SET credits_reserved = $2 WHERE id = $1`, jobID, credits); err != nil {
+		return err
+	}
+	query = `UPDATE credit_balances SET balance = balance + $2, updated_at = NOW() WHERE user_id = $1`
+	if _, err := tx.Exec(ctx, query, userID, reserved-credits); err != nil {
+		return err
+	}
+	if _, err := addCreditTransaction(ctx, tx, userID, jobID, CreditRefund, reserved-credits, description); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func addCreditTransaction(ctx context.Context, tx pgx.Tx, userID int, jobID int, kind string, amount int, description string) (*CreditTransaction, error) {
+	t := CreditTransaction{UserID: userID, JobID: jobID, Kind: kind, Amount: amount, Description: description}
+	query := `INSERT INTO credit_transactions (user_id, job_id, kind, amount, balance, description)
+		VALUES ($1, NULLIF($2, 0), $3, $4, (SELECT balance FROM credit_balances WHERE user_id = $1), $5)
+		RETURNING id, balance, created_at`
+	err := tx.QueryRow(ctx, query, userID, jobID, kind, amount, description).Scan(&t.ID, &t.Balance, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
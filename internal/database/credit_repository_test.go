@@ -0,0 +1,61 @@
+package database
+
+import "testing"
+
+func TestFinishJobCredits(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		succeeded   bool
+		wantState   string
+		wantKind    string
+		wantBalance int
+	}{
+		{name: "succeeded job is charged", succeeded: true, wantState: CreditsSettled, wantKind: CreditSettle, wantBalance: 70},
+		{name: "failed job is refunded", succeeded: false, wantState: CreditsRefunded, wantKind: CreditRefund, wantBalance: 100},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := testDB(t)
+			userID := newTestUser(t, db, 100)
+			job := newTestJob(t, db, userID, 30)
+
+			// The second call finds the credits finished and changes nothing.
+			for i := 0; i < 2; i++ {
+				if err := FinishJobCredits(db, job.ID, tc.succeeded); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if balance, err := GetCreditBalance(db, userID); err != nil || balance != tc.wantBalance {
+				t.Errorf("balance is %d, %v, want %d", balance, err, tc.wantBalance)
+			}
+			stored, err := GetGenerationJobByID(db, job.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.CreditsState != tc.wantState {
+				t.Errorf("credits state is %q, want %q", stored.CreditsState, tc.wantState)
+			}
+
+			transactions, err := ListCreditTransactions(db, userID, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kinds []string
+			for _, tr := range transactions {
+				kinds = append(kinds, tr.Kind)
+			}
+			want := []string{tc.wantKind, CreditReserve, CreditGrant}
+			if len(kinds) != len(want) {
+				t.Fatalf("ledger is %v, want %v", kinds, want)
+			}
+			for i := range want {
+				if kinds[i] != want[i] {
+					t.Fatalf("ledger is %v, want %v", kinds, want)
+				}
+			}
+			if transactions[0].Balance != tc.wantBalance {
+				t.Errorf("last ledger balance is %d, want %d", transactions[0].Balance, tc.wantBalance)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go-project/internal/blob"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Generation is the permanent record of a generation job: what it was made
+// from, the provider tasks, the raw model before conversion and the time
+// spent in every stage. The source and the conversions stay on the job.
+type Generation struct {
+	ID             int
+	JobID          int
+	Status         string
+	GenerateTaskID string
+	ModelURL       string
+	ModelSize      int
+	MeshID         int
+	CreatedAt      time.Time
+	FinishedAt     *time.Time
+}
+
+type GenerationStage struct {
+	Stage      string
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+func insertGeneration(ctx context.Context, tx pgx.Tx, job *GenerationJob) error {
+	query := `INSERT INTO generations (job_id, status, mesh_id, finished_at)
+		VALUES ($1, $2, NULLIF($3, 0), CASE WHEN $4 THEN NOW() END)`
+	_, err := tx.Exec(ctx, query, job.ID, job.Status, job.MeshID, job.MeshID != 0)
+	return err
+}
+
+// RecordGenerationState copies the job state to its generation. The stage
+// key starts a new stage timing when it changes; final stops all timings.
+func RecordGenerationState(db *pgxpool.Pool, job *GenerationJob, stage string, final bool) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	query := `UPDATE generations
+		SET status = $2, generate_task_id = $3, mesh_id = NULLIF($4, 0),
+			finished_at = CASE WHEN $5 THEN NOW() END
+		WHERE job_id = $1
+		RETURNING id`
+	err = tx.QueryRow(ctx, query, job.ID, job.Status, job.GenerateTaskID, job.MeshID, final).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	query = `UPDATE generation_stages SET finished_at = NOW()
+		WHERE generation_id = $1 AND finished_at IS NULL AND ($2 OR stage <> $3)`
+	if _, err := tx.Exec(ctx, query, id, final, stage); err != nil {
+		return err
+	}
+	if !final {
+		query := `INSERT INTO generation_stages (generation_id, stage) VALUES ($1, $2)
+			ON CONFLICT (generation_id, stage) DO NOTHING`
+		if _, err := tx.Exec(ctx, query, id, stage); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// SetGenerationModel stores the model produced by the generate task, before
+// any conversion. An empty ref only records the URL.
+func SetGenerationModel(db *pgxpool.Pool, jobID int, modelURL string, ref blob.Ref) error {
+	query := `UPDATE generations SET model_url = $2, model_key = NULLIF($3, ''), model_size = $4, model_sha256 = $5
+		WHERE job_id = $1`
+	_, err := db.Exec(context.Background(), query, jobID, modelURL, ref.Key, ref.Size, ref.SHA256)
+	return err
+}
+
+func GetGenerationByID(db *pgxpool.Pool, id int) (*Generation, error) {
+	query := `SELECT id, job_id, status, generate_task_id, model_url, model_size,
+			COALESCE(mesh_id, 0), created_at, finished_at
+		FROM generations WHERE id = $1`
+	var g Generation
+	err := db.QueryRow(context.Background(), query, id).Scan(&g.ID, &g.JobID, &g.Status, &g.GenerateTaskID,
+		&g.ModelURL, &g.ModelSize, &g.MeshID, &g.CreatedAt, &g.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func GetGenerationIDByJobID(db *pgxpool.Pool, jobID int) (int, error) {
+	var id int
+	err := db.QueryRow(context.Background(), `SELECT id FROM generations WHERE job_id = $1`, jobID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetGenerationModel returns the blob of the raw model of a generation, or
+// the model itself for rows that have not been moved to the blob store. Both
+// are empty when the model has not been downloaded.
+func GetGenerationModel(db *pgxpool.Pool, id int) (blob.Ref, []byte, error) {
+	var ref blob.Ref
+	var model []byte
+	query := `SELECT COALESCE(model_key, ''), model_size, model_sha256, CASE WHEN model_key IS NULL THEN model END
+		FROM generations WHERE id = $1`
+	err := db.QueryRow(context.Background(), query, id).Scan(&ref.Key, &ref.Size, &ref.SHA256, &model)
+	if err != nil {
+		return blob.Ref{}, nil, err
+	}
+	return ref, model, nil
+}
+
+// GetGenerationSourceImage returns the filename and the image a generation
+// was made from, as a blob reference or, for rows not moved yet, the image
+// itself. Both are empty for text and multiview generations.
+func GetGenerationSourceImage(db *pgxpool.Pool, id int) (string, blob.Ref, []byte, error) {
+	var filename string
+	var ref blob.Ref
+	var image []byte
+	query := `SELECT j.filename, COALESCE(j.source_image_key, ''), j.source_image_size, j.source_image_sha256,
+			CASE WHEN j.source_image_key IS NULL THEN j.source_image END
+		FROM generations g JOIN generation_jobs j ON j.id = g.job_id
+		WHERE g.id = $1`
+	err := db.QueryRow(context.Background(), query, id).Scan(&filename, &ref.Key, &ref.Size, &ref.SHA256, &image)
+	if err != nil {
+		return "", blob.Ref{}, nil, err
+	}
+	return filename, ref, image, nil
+}
+
+func ListGenerationStages(db *pgxpool.Pool, generationID int) ([]GenerationStage, error) {
+	query := `SELECT stage, started_at, finished_at FROM generation_stages
+		WHERE generation_id = $1 ORDER BY started_at`
+	rows, err := db.Query(context.Background(), query, generationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stages []GenerationStage
+	for rows.Next() {
+		var s GenerationStage
+		if err := rows.Scan(&s.Stage, &s.StartedAt, &s.FinishedAt); err != nil {
+			return nil, err
+		}
+		stages = append(stages, s)
+	}
+	return stages, rows.Err()
+}
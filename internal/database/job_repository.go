@@ -0,0 +1,517 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go-project/internal/blob"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// GenerationJob is a generation run by the cloud provider or, with Backend
+// "local", by the local script job linked in ScriptJobID. A cloud job with
+// Fallback set moves to the local backend when the provider fails it and
+// keeps the cause in FallbackReason. The source image lives in the blob
+// store; SourceImage is only filled for rows not moved there yet, and by the
+// caller creating a job.
+type GenerationJob struct {
+	ID              int
+	Status          string
+	Stage           string
+	Mode            string
+	Filename        string
+	SourceImageRef  blob.Ref
+	SourceImage     []byte
+	Prompt          string
+	NegativePrompt  string
+	Format          string
+	Quad            bool
+	FaceLimit       int
+	ClientID        string
+	UserID          int
+	CreditsReserved int
+	CreditsState    string
+	CallbackURL     string
+	CallbackSecret  string
+	ImageToken      string
+	GenerateTaskID  string
+	Progress        int
+	QueuingNum      int
+	RunningLeftTime int
+	MeshID          int
+	TargetMeshID    int
+	Error           string
+	Attempts        int
+	CacheKey        string
+	CachedFromJobID int
+	Fallback        bool
+	ScriptJobID     int
+	Backend         string
+	FallbackReason  string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Conversions     []JobConversion
+	Images          []JobImage
+}
+
+// JobImage is one source image of a multiview job. Data is only filled for
+// rows not moved to the blob store yet, and by the caller creating a job.
+type JobImage struct {
+	ID         int
+	JobID      int
+	View       string
+	Filename   string
+	Ref        blob.Ref
+	Data       []byte
+	Size       int
+	ImageToken string
+}
+
+// IdempotencyKey remembers which job was created for an Idempotency-Key
+// header of a client, so a retried request does not create another one.
+type IdempotencyKey struct {
+	ClientID    string
+	Key         string
+	RequestHash string
+	JobID       int
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// JobConversion is one convert_model task started from the generated model.
+type JobConversion struct {
+	ID               int
+	JobID            int
+	Format           string
+	Quad             bool
+	FaceLimit        int
+	TaskID           string
+	Status           string
+	Progress         int
+	ModelURL         string
+	RepresentationID int
+	Error            string
+}
+
+const generationJobColumns = `id, status, stage, mode, filename, COALESCE(source_image_key, ''),
+	source_image_size, source_image_sha256, prompt, negative_prompt, format,
+	quad, face_limit, client_id, COALESCE(user_id, 0), credits_reserved, credits_state, callback_url,
+	callback_secret, image_token, generate_task_id, progress, queuing_num, running_left_time,
+	COALESCE(mesh_id, 0), error, attempts, cache_key, COALESCE(cached_from_job_id, 0), fallback,
+	COALESCE(fallback_script_job_id, 0), COALESCE(target_mesh_id, 0), backend, fallback_reason,
+	created_at, updated_at`
+
+func scanGenerationJob(row pgx.Row, extra ...any) (*GenerationJob, error) {
+	var job GenerationJob
+	dest := []any{
+		&job.ID, &job.Status, &job.Stage, &job.Mode, &job.Filename, &job.SourceImageRef.Key,
+		&job.SourceImageRef.Size, &job.SourceImageRef.SHA256, &job.Prompt, &job.NegativePrompt,
+		&job.Format, &job.Quad, &job.FaceLimit, &job.ClientID, &job.UserID, &job.CreditsReserved,
+		&job.CreditsState, &job.CallbackURL, &job.CallbackSecret, &job.ImageToken, &job.GenerateTaskID,
+		&job.Progress, &job.QueuingNum, &job.RunningLeftTime, &job.MeshID, &job.Error, &job.Attempts,
+		&job.CacheKey, &job.CachedFromJobID, &job.Fallback, &job.ScriptJobID, &job.TargetMeshID,
+		&job.Backend, &job.FallbackReason, &job.CreatedAt, &job.UpdatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CreateGenerationJob inserts the job together with its conversions and
+// source images.
+func CreateGenerationJob(db *pgxpool.Pool, job *GenerationJob) (int, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	id, err := insertGenerationJob(ctx, tx, job)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// CreateIdempotentGenerationJob inserts the job unless key has already been
+// used. In that case nothing is inserted and the stored key is returned. A
+// concurrent request with the same key waits until the first one commits.
+// Expired keys are removed first.
+func CreateIdempotentGenerationJob(db *pgxpool.Pool, job *GenerationJob, key *IdempotencyKey) (*IdempotencyKey, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at < NOW()`); err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO idempotency_keys (client_id, key, request_hash, expires_at)
+		VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`
+	tag, err := tx.Exec(ctx, query, key.ClientID, key.Key, key.RequestHash, key.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		var stored IdempotencyKey
+		query := `SELECT client_id, key, request_hash, COALESCE(job_id, 0), created_at, expires_at
+			FROM idempotency_keys WHERE client_id = $1 AND key = $2`
+		err := tx.QueryRow(ctx, query, key.ClientID, key.Key).Scan(&stored.ClientID, &stored.Key,
+			&stored.RequestHash, &stored.JobID, &stored.CreatedAt, &stored.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		return &stored, nil
+	}
+
+	id, err := insertGenerationJob(ctx, tx, job)
+	if err != nil {
+		return nil, err
+	}
+	query = `UPDATE idempotency_keys SET job_id = $3 WHERE client_id = $1 AND key = $2`
+	if _, err := tx.Exec(ctx, query, key.ClientID, key.Key, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	job.ID = id
+	key.JobID = id
+	return nil, nil
+}
+
+func insertGenerationJob(ctx context.Context, tx pgx.Tx, job *GenerationJob) (int, error) {
+	var id int
+	query := `INSERT INTO generation_jobs (status, stage, mode, filename, source_image_key,
+			source_image_size, source_image_sha256, prompt, negative_prompt, format, quad, face_limit,
+			client_id, callback_url, callback_secret, progress, mesh_id, cache_key, cached_from_job_id,
+			user_id, credits_reserved, credits_state, fallback, target_mesh_id, backend, fallback_reason)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16,
+			NULLIF($17, 0), $18, NULLIF($19, 0), NULLIF($20, 0), $21, $22, $23, NULLIF($24, 0), $25, $26)
+		RETURNING id`
+	err := tx.QueryRow(ctx, query, job.Status, job.Stage, job.Mode, job.Filename, job.SourceImageRef.Key,
+		job.SourceImageRef.Size, job.SourceImageRef.SHA256, job.Prompt, job.NegativePrompt, job.Format,
+		job.Quad, job.FaceLimit, job.ClientID, job.CallbackURL, job.CallbackSecret, job.Progress,
+		job.MeshID, job.CacheKey, job.CachedFromJobID, job.UserID, job.CreditsReserved, job.CreditsState,
+		job.Fallback, job.TargetMeshID, job.Backend, job.FallbackReason).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	job.ID = id
+
+	if err := insertGeneration(ctx, tx, job); err != nil {
+		return 0, err
+	}
+
+	if job.CreditsState == CreditsReserved {
+		if err := reserveCredits(ctx, tx, job); err != nil {
+			return 0, err
+		}
+	}
+
+	for i := range job.Conversions {
+		c := &job.Conversions[i]
+		query := `INSERT INTO generation_job_conversions (job_id, format, quad, face_limit, status,
+				progress, model_url, representation_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0)) RETURNING id`
+		err := tx.QueryRow(ctx, query, id, c.Format, c.Quad, c.FaceLimit, c.Status, c.Progress,
+			c.ModelURL, c.RepresentationID).Scan(&c.ID)
+		if err != nil {
+			return 0, err
+		}
+		c.JobID = id
+	}
+
+	for i := range job.Images {
+		image := &job.Images[i]
+		query := `INSERT INTO job_images (job_id, view, filename, data_key, data_size, data_sha256)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+		err := tx.QueryRow(ctx, query, id, image.View, image.Filename, image.Ref.Key, image.Ref.Size,
+			image.Ref.SHA256).Scan(&image.ID)
+		if err != nil {
+			return 0, err
+		}
+		image.JobID = id
+	}
+
+	return id, nil
+}
+
+// GetGenerationJobByID returns the job with its conversions and the metadata
+// of its source images.
+func GetGenerationJobByID(db *pgxpool.Pool, id int) (*GenerationJob, error) {
+	query := `SELECT ` + generationJobColumns + ` FROM generation_jobs WHERE id = $1`
+	job, err := scanGenerationJob(db.QueryRow(context.Background(), query, id))
+	if err != nil {
+		return nil, err
+	}
+
+	job.Conversions, err = ListJobConversions(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Images, err = ListJobImages(db, id, false)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// FindCachedGenerationJob returns the latest job of the user that generated
+// the result for cacheKey, with its conversions, or nil when there is none.
+// Its mesh must still be live and at the revision the job created, so a mesh
+// edited or restored since is not served as the cached result.
+func FindCachedGenerationJob(db *pgxpool.Pool, cacheKey string, userID int) (*GenerationJob, error) {
+	query := `SELECT ` + generationJobColumns + ` FROM generation_jobs
+		WHERE cache_key = $1 AND user_id = $2 AND status = 'succeeded' AND cached_from_job_id IS NULL
+			AND EXISTS (SELECT 1 FROM mesh_objects m JOIN mesh_revisions r ON r.id = m.current_revision_id
+				WHERE m.id = generation_jobs.mesh_id AND m.deleted_at IS NULL AND r.job_id = generation_jobs.id)
+		ORDER BY id DESC LIMIT 1`
+	job, err := scanGenerationJob(db.QueryRow(context.Background(), query, cacheKey, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	job.Conversions, err = ListJobConversions(db, job.ID)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func ListJobConversions(db *pgxpool.Pool, jobID int) ([]JobConversion, error) {
+	query := `SELECT id, job_id, format, quad, face_limit, task_id, status, progress, model_url,
+			COALESCE(representation_id, 0), error
+		FROM generation_job_conversions WHERE job_id = $1 ORDER BY id`
+	rows, err := db.Query(context.Background(), query, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversions []JobConversion
+	for rows.Next() {
+		var c JobConversion
+		err := rows.Scan(&c.ID, &c.JobID, &c.Format, &c.Quad, &c.FaceLimit, &c.TaskID, &c.Status,
+			&c.Progress, &c.ModelURL, &c.RepresentationID, &c.Error)
+		if err != nil {
+			return nil, err
+		}
+		conversions = append(conversions, c)
+	}
+	return conversions, rows.Err()
+}
+
+// UpdateJobConversion stores c unless the job has been claimed again since
+// attempt.
+func UpdateJobConversion(db *pgxpool.Pool, c *JobConversion, attempt int) error {
+	query := `UPDATE generation_job_conversions
+		SET task_id = $2, status = $3, progress = $4, model_url = $5,
+			representation_id = NULLIF($6, 0), error = $7
+		WHERE id = $1 AND EXISTS (SELECT 1 FROM generation_jobs j
+			WHERE j.id = generation_job_conversions.job_id AND j.attempts = $8)`
+	_, err := db.Exec(context.Background(), query, c.ID, c.TaskID, c.Status, c.Progress,
+		c.ModelURL, c.RepresentationID, c.Error, attempt)
+	return err
+}
+
+// ListJobImages returns the source images of a job ordered by ID. The data
+// of images not moved to the blob store yet is only loaded when withData is
+// set.
+func ListJobImages(db *pgxpool.Pool, jobID int, withData bool) ([]JobImage, error) {
+	query := `SELECT id, job_id, view, filename, data_size, image_token, COALESCE(data_key, ''),
+			data_sha256, CASE WHEN $2 AND data_key IS NULL THEN data END
+		FROM job_images WHERE job_id = $1 ORDER BY id`
+	rows, err := db.Query(context.Background(), query, jobID, withData)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []JobImage
+	for rows.Next() {
+		var image JobImage
+		err := rows.Scan(&image.ID, &image.JobID, &image.View, &image.Filename, &image.Size,
+			&image.ImageToken, &image.Ref.Key, &image.Ref.SHA256, &image.Data)
+		if err != nil {
+			return nil, err
+		}
+		image.Ref.Size = image.Size
+		images = append(images, image)
+	}
+	return images, rows.Err()
+}
+
+// UpdateJobImageToken stores the provider file token of an uploaded image
+// unless the job has been claimed again since attempt.
+func UpdateJobImageToken(db *pgxpool.Pool, image *JobImage, attempt int) error {
+	query := `UPDATE job_images SET image_token = $2
+		WHERE id = $1 AND EXISTS (SELECT 1 FROM generation_jobs j
+			WHERE j.id = job_images.job_id AND j.attempts = $3)`
+	_, err := db.Exec(context.Background(), query, image.ID, image.ImageToken, attempt)
+	return err
+}
+
+// ClaimGenerationJob locks the oldest queued cloud job, or a running one
+// whose lease has expired because its worker died, and leases it for lease.
+// It returns nil when there is nothing to do. The returned job includes the
+// source images and the conversions. Every claim increments attempts, which
+// the updates of the worker holding the claim check.
+func ClaimGenerationJob(db *pgxpool.Pool, lease time.Duration) (*GenerationJob, error) {
+	query := `UPDATE generation_jobs
+		SET status = 'running', attempts = attempts + 1,
+			locked_until = NOW() + make_interval(secs => $1), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM generation_jobs
+			WHERE backend = 'cloud' AND (status = 'queued' OR (status = 'running' AND locked_until < NOW()))
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING ` + generationJobColumns + `, CASE WHEN source_image_key IS NULL THEN source_image END`
+
+	var image []byte
+	job, err := scanGenerationJob(db.QueryRow(context.Background(), query, lease.Seconds()), &image)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	job.SourceImage = image
+
+	job.Conversions, err = ListJobConversions(db, job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Images, err = ListJobImages(db, job.ID, true)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// UpdateGenerationJob stores the job state and extends its lease. It reports
+// false when nothing was stored because the job has been cancelled or claimed
+// again since job.Attempts.
+func UpdateGenerationJob(db *pgxpool.Pool, job *GenerationJob, lease time.Duration) (bool, error) {
+	query := `UPDATE generation_jobs
+		SET status = $2, stage = $3, image_token = $4, generate_task_id = $5, progress = $6,
+			queuing_num = $7, running_left_time = $8, mesh_id = NULLIF($9, 0), error = $10,
+			backend = $11, fallback_reason = $12,
+			locked_until = NOW() + make_interval(secs => $13), updated_at = NOW()
+		WHERE id = $1 AND status <> 'cancelled' AND attempts = $14`
+	tag, err := db.Exec(context.Background(), query, job.ID, job.Status, job.Stage, job.ImageToken,
+		job.GenerateTaskID, job.Progress, job.QueuingNum, job.RunningLeftTime, job.MeshID, job.Error,
+		job.Backend, job.FallbackReason, lease.Seconds(), job.Attempts)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RenewGenerationJobLease extends the lease of a running job. It reports
+// false when the job is no longer running under this attempt.
+func RenewGenerationJobLease(db *pgxpool.Pool, id int, attempt int, lease time.Duration) (bool, error) {
+	query := `UPDATE generation_jobs SET locked_until = NOW() + make_interval(secs => $3)
+		WHERE id = $1 AND attempts = $2 AND status = 'running'`
+	tag, err := db.Exec(context.Background(), query, id, attempt, lease.Seconds())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// SetFallbackScriptJob links the script job that runs a local job.
+func SetFallbackScriptJob(db *pgxpool.Pool, jobID int, scriptJobID int) error {
+	query := `UPDATE generation_jobs SET fallback_script_job_id = $2 WHERE id = $1`
+	_, err := db.Exec(context.Background(), query, jobID, scriptJobID)
+	return err
+}
+
+// FinishLocalGenerationJob stores the outcome of a running local job and
+// returns the job. It returns pgx.ErrNoRows when the job is not a running
+// local job, for example because it was cancelled.
+func FinishLocalGenerationJob(db *pgxpool.Pool, job *GenerationJob) (*GenerationJob, error) {
+	query := `UPDATE generation_jobs
+		SET status = $2, stage = $3, progress = $4, mesh_id = NULLIF($5, 0), error = $6,
+			fallback_script_job_id = COALESCE(NULLIF($7, 0), fallback_script_job_id), updated_at = NOW()
+		WHERE id = $1 AND backend = 'local' AND status = 'running'
+		RETURNING ` + generationJobColumns
+	finished, err := scanGenerationJob(db.QueryRow(context.Background(), query, job.ID, job.Status,
+		job.Stage, job.Progress, job.MeshID, job.Error, job.ScriptJobID))
+	if err != nil {
+		return nil, err
+	}
+
+	finished.Conversions, err = ListJobConversions(db, job.ID)
+	if err != nil {
+		return nil, err
+	}
+	return finished, nil
+}
+
+// FailOrphanedLocalGenerationJobs fails the running local jobs no queued or
+// running script job works on, because their process died before it
+// created one, and returns them.
+func FailOrphanedLocalGenerationJobs(db *pgxpool.Pool, reason string) ([]GenerationJob, error) {
+	query := `UPDATE generation_jobs j SET status = 'failed', error = $1, updated_at = NOW()
+		WHERE backend = 'local' AND status = 'running' AND NOT EXISTS (
+			SELECT 1 FROM script_jobs s WHERE s.generation_job_id = j.id AND s.status IN ('queued', 'running'))
+		RETURNING ` + generationJobColumns
+	rows, err := db.Query(context.Background(), query, reason)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var failed []GenerationJob
+	for rows.Next() {
+		job, err := scanGenerationJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		failed = append(failed, *job)
+	}
+	return failed, rows.Err()
+}
+
+// CancelGenerationJob marks a queued or running job as cancelled. It reports
+// false when the job does not exist or has already finished.
+func CancelGenerationJob(db *pgxpool.Pool, id int) (bool, error) {
+	query := `UPDATE generation_jobs SET status = 'cancelled', error = 'cancelled by user', updated_at = NOW()
+		WHERE id = $1 AND status IN ('queued', 'running')`
+	tag, err := db.Exec(context.Background(), query, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func CountInFlightGenerationJobs(db *pgxpool.Pool) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM generation_jobs WHERE backend = 'cloud' AND status = 'running'`
+	err := db.QueryRow(context.Background(), query).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
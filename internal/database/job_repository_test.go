@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testDB connects to TEST_DATABASE_URL, which must name a scratch database
+// with the schema and all migrations applied. The tests add rows to it and
+// fail every job left queued or running, so they can claim their own jobs.
+func testDB(t *testing.T) *pgxpool.Pool {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	query := `UPDATE generation_jobs SET status = 'failed', error = 'left over by a test'
+		WHERE status IN ('queued', 'running')`
+	if _, err := db.Exec(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestUser(t *testing.T, db *pgxpool.Pool, credits int) int {
+	userID, err := CreateUser(db, fmt.Sprintf("test-%d", time.Now().UnixNano()), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GrantCredits(db, userID, credits, "test credits"); err != nil {
+		t.Fatal(err)
+	}
+	return userID
+}
+
+// newTestJob creates a queued cloud text job. With userID set it reserves
+// credits from that user.
+func newTestJob(t *testing.T, db *pgxpool.Pool, userID int, credits int) *GenerationJob {
+	job := &GenerationJob{Status: "queued", Stage: "upload", Mode: "text", Prompt: "a chair",
+		Format: "GLB", Backend: "cloud", UserID: userID}
+	if credits > 0 {
+		job.CreditsReserved = credits
+		job.CreditsState = CreditsReserved
+	}
+	if _, err := CreateGenerationJob(db, job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestClaimGenerationJobIsExclusive(t *testing.T) {
+	db := testDB(t)
+	const jobs, workers = 20, 8
+	for i := 0; i < jobs; i++ {
+		newTestJob(t, db, 0, 0)
+	}
+
+	var mu sync.Mutex
+	claims := map[int]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := ClaimGenerationJob(db, time.Minute)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if job == nil {
+					return
+				}
+				mu.Lock()
+				claims[job.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claims) != jobs {
+		t.Errorf("claimed %d jobs, want %d", len(claims), jobs)
+	}
+	for id, n := range claims {
+		if n != 1 {
+			t.Errorf("job %d was claimed %d times", id, n)
+		}
+	}
+}
+
+func TestUpdateGenerationJobRejectsStaleAttempt(t *testing.T) {
+	db := testDB(t)
+	job := newTestJob(t, db, 0, 0)
+
+	// The first lease is expired at once, as if its worker had died.
+	first, err := ClaimGenerationJob(db, -time.Second)
+	if err != nil || first == nil || first.ID != job.ID {
+		t.Fatalf("first claim returned %v, %v", first, err)
+	}
+	second, err := ClaimGenerationJob(db, time.Minute)
+	if err != nil || second == nil || second.ID != job.ID {
+		t.Fatalf("second claim returned %v, %v", second, err)
+	}
+	if second.Attempts != first.Attempts+1 {
+		t.Errorf("attempts went from %d to %d", first.Attempts, second.Attempts)
+	}
+
+	first.Stage = "generate"
+	if stored, err := UpdateGenerationJob(db, first, time.Minute); err != nil || stored {
+		t.Errorf("update of the stale attempt returned %v, %v", stored, err)
+	}
+	if renewed, err := RenewGenerationJobLease(db, job.ID, first.Attempts, time.Minute); err != nil || renewed {
+		t.Errorf("lease renewal of the stale attempt returned %v, %v", renewed, err)
+	}
+
+	second.Stage = "convert"
+	if stored, err := UpdateGenerationJob(db, second, time.Minute); err != nil || !stored {
+		t.Errorf("update of the current attempt returned %v, %v", stored, err)
+	}
+	stored, err := GetGenerationJobByID(db, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Stage != "convert" {
+		t.Errorf("stored stage %s, want convert", stored.Stage)
+	}
+}
+
+func TestUpdateGenerationJobIgnoresCancelledJob(t *testing.T) {
+	db := testDB(t)
+	job := newTestJob(t, db, 0, 0)
+
+	claimed, err := ClaimGenerationJob(db, time.Minute)
+	if err != nil || claimed == nil || claimed.ID != job.ID {
+		t.Fatalf("claim returned %v, %v", claimed, err)
+	}
+	if cancelled, err := CancelGenerationJob(db, job.ID); err != nil || !cancelled {
+		t.Fatalf("cancel returned %v, %v", cancelled, err)
+	}
+
+	claimed.Status = "succeeded"
+	if stored, err := UpdateGenerationJob(db, claimed, time.Minute); err != nil || stored {
+		t.Errorf("update of the cancelled job returned %v, %v", stored, err)
+	}
+	if cancelled, err := CancelGenerationJob(db, job.ID); err != nil || cancelled {
+		t.Errorf("second cancel returned %v, %v", cancelled, err)
+	}
+}
+
+func TestCreateGenerationJobReservesCredits(t *testing.T) {
+	db := testDB(t)
+	userID := newTestUser(t, db, 50)
+
+	newTestJob(t, db, userID, 30)
+	if balance, err := GetCreditBalance(db, userID); err != nil || balance != 20 {
+		t.Errorf("balance after the reservation is %d, %v, want 20", balance, err)
+	}
+
+	job := &GenerationJob{Status: "queued", Stage: "upload", Mode: "text", Prompt: "a table",
+		Format: "GLB", Backend: "cloud", UserID: userID, CreditsReserved: 30, CreditsState: CreditsReserved}
+	if _, err := CreateGenerationJob(db, job); !errors.Is(err, ErrInsufficientCredits) {
+		t.Errorf("job beyond the balance returned %v, want ErrInsufficientCredits", err)
+	}
+	if balance, err := GetCreditBalance(db, userID); err != nil || balance != 20 {
+		t.Errorf("balance after the rejected job is %d, %v, want 20", balance, err)
+	}
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SoftDeleteMeshObject marks the mesh of ownerID as deleted and returns
+// when. Deleted meshes are hidden from every other query but keep their data
+// until they are purged.
+func SoftDeleteMeshObject(db *pgxpool.Pool, id int, ownerID int) (time.Time, error) {
+	var deletedAt time.Time
+	query := `UPDATE mesh_objects SET deleted_at = NOW()
+		WHERE id = $1 AND owner_id = $2 AND deleted_at IS NULL RETURNING deleted_at`
+	err := db.QueryRow(context.Background(), query, id, ownerID).Scan(&deletedAt)
+	return deletedAt, err
+}
+
+// UndeleteMeshObject clears the deletion of a mesh of ownerID deleted after
+// since.
+func UndeleteMeshObject(db *pgxpool.Pool, id int, ownerID int, since time.Time) error {
+	tag, err := db.Exec(context.Background(), `UPDATE mesh_objects SET deleted_at = NULL
+		WHERE id = $1 AND owner_id = $2 AND deleted_at IS NOT NULL AND deleted_at > $3`, id, ownerID, since)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ListExpiredMeshObjects returns up to limit meshes deleted before the
+// given time.
+func ListExpiredMeshObjects(db *pgxpool.Pool, before time.Time, limit int) ([]int, error) {
+	rows, err := db.Query(context.Background(), `SELECT id FROM mesh_objects
+		WHERE deleted_at IS NOT NULL AND deleted_at <= $1 ORDER BY deleted_at LIMIT $2`, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// PurgeMeshObject removes a mesh deleted before the given time with its
+// revisions and representations. Jobs, generations and script jobs that
+// produced it keep their rows but no longer point at it. The blobs are left
+// to the collector, as other rows may share them.
+func PurgeMeshObject(db *pgxpool.Pool, id int, before time.Time) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked int
+	err = tx.QueryRow(ctx, `SELECT id FROM mesh_objects
+		WHERE id = $1 AND deleted_at IS NOT NULL AND deleted_at <= $2 FOR UPDATE`, id, before).Scan(&locked)
+	if err != nil {
+		return err
+	}
+
+	queries := []string{
+		`UPDATE generation_job_conversions SET representation_id = NULL
+			WHERE representation_id IN (SELECT id FROM mesh_representations WHERE mesh_id = $1)`,
+		`UPDATE generation_jobs SET mesh_id = NULL WHERE mesh_id = $1`,
+		`UPDATE generation_jobs SET target_mesh_id = NULL WHERE target_mesh_id = $1`,
+		`UPDATE generations SET mesh_id = NULL WHERE mesh_id = $1`,
+		`UPDATE script_jobs SET mesh_id = NULL WHERE mesh_id = $1`,
+		`UPDATE mesh_objects SET current_revision_id = NULL WHERE id = $1`,
+		`DELETE FROM mesh_representations WHERE mesh_id = $1`,
+		`DELETE FROM mesh_revisions WHERE mesh_id = $1`,
+		`DELETE FROM mesh_objects WHERE id = $1`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(ctx, query, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// meshSortColumns maps the sort keys of ListMeshObjects to their columns.
+var meshSortColumns = map[string]string{
+	"id":          "m.id",
+	"upload_time": "m.upload_time",
+	"name":        "m.name",
+	"size":        "m.blob_size",
+}
+
+var ErrInvalidMeshCursor = errors.New("invalid cursor")
+
+// MeshSortKeys lists the values accepted by MeshFilter.Sort.
+var MeshSortKeys = []string{"id", "upload_time", "name", "size"}
+
+// MeshFilter selects mesh objects for ListMeshObjects. Zero fields do not
+// filter. Format matches any representation of the current revision of a
+// mesh, Name is a case-insensitive substring.
+type MeshFilter struct {
+	OwnerID        int
+	Format         string
+	Name           string
+	UploadedAfter  *time.Time
+	UploadedBefore *time.Time
+	GenerationID   int
+
+	Sort   string
+	Desc   bool
+	Cursor *MeshCursor
+	Limit  int
+}
+
+// MeshCursor is the position after which a page starts: the sort value and
+// the ID of the last mesh of the previous page.
+type MeshCursor struct {
+	Value string
+	ID    int
+}
+
+// MeshListItem is the metadata of a mesh object in a list. Data is never
+// loaded.
+type MeshListItem struct {
+	MeshObject
+	GenerationID int
+	Formats      []string
+}
+
+// CursorAfter returns the cursor of the page that follows item.
+func (f MeshFilter) CursorAfter(item MeshListItem) MeshCursor {
+	cursor := MeshCursor{ID: item.ID}
+	switch f.Sort {
+	case "upload_time":
+		cursor.Value = item.UploadTime.Format(time.RFC3339Nano)
+	case "name":
+		cursor.Value = item.Name
+	case "size":
+		cursor.Value = strconv.Itoa(item.Blob.Size)
+	default:
+		cursor.Value = strconv.Itoa(item.ID)
+	}
+	return cursor
+}
+
+// cursorValue parses the cursor value into the type of the sort column.
+func (f MeshFilter) cursorValue() (interface{}, error) {
+	switch f.Sort {
+	case "upload_time":
+		t, err := time.Parse(time.RFC3339Nano, f.Cursor.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidMeshCursor, err)
+		}
+		return t, nil
+	case "name":
+		return f.Cursor.Value, nil
+	default:
+		n, err := strconv.Atoi(f.Cursor.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidMeshCursor, err)
+		}
+		return n, nil
+	}
+}
+
+// ListMeshObjects returns one page of mesh objects ordered by the sort key
+// and then by ID.
+func ListMeshObjects(db *pgxpool.Pool, f MeshFilter) ([]MeshListItem, error) {
+	column, ok := meshSortColumns[f.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort key %q", f.Sort)
+	}
+
+	where := []string{"m.deleted_at IS NULL"}
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.OwnerID != 0 {
+		where = append(where, "m.owner_id = "+arg(f.OwnerID))
+	}
+	if f.Format != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM mesh_representations r WHERE r.revision_id = m.current_revision_id AND r.format = "+
+			arg(f.Format)+")")
+	}
+	if f.Name != "" {
+		where = append(where, "m.name ILIKE "+arg("%"+escapeLike(f.Name)+"%"))
+	}
+	if f.UploadedAfter != nil {
+		where = append(where, "m.upload_time >= "+arg(*f.UploadedAfter))
+	}
+	if f.UploadedBefore != nil {
+		where = append(where, "m.upload_time < "+arg(*f.UploadedBefore))
+	}
+	if f.GenerationID != 0 {
+		where = append(where, "EXISTS (SELECT 1 FROM generations g WHERE g.mesh_id = m.id AND g.id = "+
+			arg(f.GenerationID)+")")
+	}
+
+	order, compare := "ASC", ">"
+	if f.Desc {
+		order, compare = "DESC", "<"
+	}
+	if f.Cursor != nil {
+		value, err := f.cursorValue()
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, fmt.Sprintf("(%s, m.id) %s (%s, %s)", column, compare, arg(value), arg(f.Cursor.ID)))
+	}
+
+	query := `SELECT m.id, m.name, COALESCE(m.owner_id, 0), COALESCE(m.blob_key, ''), m.blob_size, m.blob_sha256,
+			m.format, m.quad, m.face_limit, m.detected_format, m.geometry, m.upload_time,
+			COALESCE((SELECT MIN(g.id) FROM generations g WHERE g.mesh_id = m.id), 0),
+			ARRAY(SELECT DISTINCT r.format FROM mesh_representations r
+				WHERE r.revision_id = m.current_revision_id ORDER BY r.format)
+		FROM mesh_objects m`
+	query += " WHERE " + strings.Join(where, " AND ")
+	query += fmt.Sprintf(" ORDER BY %s %s, m.id %s LIMIT %s", column, order, order, arg(f.Limit))
+
+	rows, err := db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []MeshListItem
+	for rows.Next() {
+		var item MeshListItem
+		err := rows.Scan(&item.ID, &item.Name, &item.OwnerID, &item.Blob.Key, &item.Blob.Size, &item.Blob.SHA256,
+			&item.Format, &item.Quad, &item.FaceLimit, &item.DetectedFormat, &item.Geometry, &item.UploadTime, &item.GenerationID, &item.Formats)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MeshRevision is one version of a mesh object. Params keeps what the
+// revision was made with, for example the generation request.
+type MeshRevision struct {
+	ID        int
+	MeshID    int
+	Number    int
+	Source    string
+	JobID     int
+	Params    map[string]interface{}
+	CreatedAt time.Time
+
+	Representations []MeshRepresentation
+}
+
+// insertMeshRevision adds rev as the next revision of the mesh. The caller
+// holds the lock on the mesh row, or has just created it.
+func insertMeshRevision(ctx context.Context, tx pgx.Tx, meshID int, rev *MeshRevision) error {
+	params := rev.Params
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	query := `INSERT INTO mesh_revisions (mesh_id, number, source, job_id, params)
+		SELECT $1, COALESCE(MAX(number), 0) + 1, $2, NULLIF($3, 0), $4 FROM mesh_revisions WHERE mesh_id = $1
+		RETURNING id, number, created_at`
+	err := tx.QueryRow(ctx, query, meshID, rev.Source, rev.JobID, params).Scan(&rev.ID, &rev.Number, &rev.CreatedAt)
+	if err != nil {
+		return err
+	}
+	rev.MeshID = meshID
+	return nil
+}
+
+// setCurrentMeshRevision points the mesh at a revision and copies the first
+// representation of the revision to the mesh row.
+func setCurrentMeshRevision(ctx context.Context, tx pgx.Tx, meshID int, revisionID int) error {
+	query := `UPDATE mesh_objects m
+		SET current_revision_id = p.revision_id, blob_key = p.blob_key, blob_size = p.blob_size,
+			blob_sha256 = p.blob_sha256, data = p.data, format = p.format, quad = p.quad,
+			face_limit = p.face_limit, detected_format = p.detected_format, geometry = p.geometry
+		FROM (SELECT * FROM mesh_representations WHERE revision_id = $2 ORDER BY id LIMIT 1) p
+		WHERE m.id = $1`
+	tag, err := tx.Exec(ctx, query, meshID, revisionID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// AddMeshRevision stores rep as the first representation of a new revision
+// of the mesh and makes that revision current. It returns the
+// representation ID.
+func AddMeshRevision(db *pgxpool.Pool, meshID int, rev *MeshRevision, rep *MeshRepresentation) (int, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	err = tx.QueryRow(ctx, `SELECT id FROM mesh_objects WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, meshID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := insertMeshRevision(ctx, tx, meshID, rev); err != nil {
+		return 0, err
+	}
+	rep.RevisionID = rev.ID
+	representationID, err := insertMeshRepresentation(ctx, tx, rep)
+	if err != nil {
+		return 0, err
+	}
+	if err := setCurrentMeshRevision(ctx, tx, meshID, rev.ID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return representationID, nil
+}
+
+// RestoreMeshRevision makes an older revision of the mesh current again.
+func RestoreMeshRevision(db *pgxpool.Pool, meshID int, number int) (*MeshRevision, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	err = tx.QueryRow(ctx, `SELECT id FROM mesh_objects WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, meshID).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	rev, err := scanMeshRevision(tx.QueryRow(ctx, meshRevisionQuery+` WHERE mesh_id = $1 AND number = $2`,
+		meshID, number))
+	if err != nil {
+		return nil, err
+	}
+	if err := setCurrentMeshRevision(ctx, tx, meshID, rev.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+// GetRepresentationRevisionID returns the revision a representation belongs
+// to.
+func GetRepresentationRevisionID(db *pgxpool.Pool, representationID int) (int, error) {
+	var id int
+	query := `SELECT COALESCE(revision_id, 0) FROM mesh_representations WHERE id = $1`
+	err := db.QueryRow(context.Background(), query, representationID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+const meshRevisionQuery = `SELECT id, mesh_id, number, source, COALESCE(job_id, 0), params, created_at
+	FROM mesh_revisions`
+
+func scanMeshRevision(row pgx.Row) (*MeshRevision, error) {
+	var rev MeshRevision
+	err := row.Scan(&rev.ID, &rev.MeshID, &rev.Number, &rev.Source, &rev.JobID, &rev.Params, &rev.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// ListMeshRevisions returns all revisions of a mesh, newest first, with the
+// metadata of their representations. A deleted mesh has no revisions.
+func ListMeshRevisions(db *pgxpool.Pool, meshID int) ([]MeshRevision, error) {
+	ctx := context.Background()
+	rows, err := db.Query(ctx, meshRevisionQuery+` WHERE mesh_id = $1
+		AND EXISTS (SELECT 1 FROM mesh_objects WHERE id = $1 AND deleted_at IS NULL)
+		ORDER BY number DESC`, meshID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []MeshRevision
+	byID := map[int]int{}
+	for rows.Next() {
+		rev, err := scanMeshRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[rev.ID] = len(revisions)
+		revisions = append(revisions, *rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	query := `SELECT id, mesh_id, revision_id, format, quad, face_limit, COALESCE(blob_key, ''), blob_size,
+			blob_sha256, detected_format, geometry, created_at
+		FROM mesh_representations WHERE mesh_id = $1 AND revision_id IS NOT NULL ORDER BY id`
+	rows, err = db.Query(ctx, query, meshID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rep MeshRepresentation
+		err := rows.Scan(&rep.ID, &rep.MeshID, &rep.RevisionID, &rep.Format, &rep.Quad, &rep.FaceLimit,
+			&rep.Blob.Key, &rep.Blob.Size, &rep.Blob.SHA256, &rep.DetectedFormat, &rep.Geometry, &rep.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := byID[rep.RevisionID]; ok {
+			revisions[i].Representations = append(revisions[i].Representations, rep)
+		}
+	}
+	return revisions, rows.Err()
+}
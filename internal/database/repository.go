@@ -6,22 +6,63 @@ import (
 	"os"
 	"time"
 
+	"go-project/internal/blob"
+
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 )
 
+// MeshObject is a stored 3D model. Its file lives in the blob store under
+// Blob; rows that have not been moved there yet keep it in a bytea column,
+// read by GetMeshFileData. Data is filled by the mesh service, not here.
+// DetectedFormat and Geometry are read from the file when it is saved. The
+// file fields mirror the first representation of CurrentRevision.
 type MeshObject struct {
-	ID         int
-	Name       string
-	Data       []byte
-	UploadTime time.Time
+	ID              int
+	Name            string
+	OwnerID         int
+	CurrentRevision int
+	Blob            blob.Ref
+	Data            []byte
+	Format          string
+	Quad            bool
+	FaceLimit       int
+	DetectedFormat  string
+	Geometry        *MeshGeometry
+	UploadTime      time.Time
+}
+
+// MeshRepresentation is one file format of a mesh object. The file of a
+// row without a blob is read by GetMeshFileData.
+type MeshRepresentation struct {
+	ID             int
+	MeshID         int
+	RevisionID     int
+	Format         string
+	Quad           bool
+	FaceLimit      int
+	Blob           blob.Ref
+	DetectedFormat string
+	Geometry       *MeshGeometry
+	CreatedAt      time.Time
+}
+
+// MeshGeometry describes the contents of a mesh file. The bounding box is
+// axis-aligned, in the units of the file.
+type MeshGeometry struct {
+	Vertices  int        `json:"vertices"`
+	Triangles int        `json:"triangles"`
+	Materials int        `json:"materials"`
+	BBoxMin   [3]float64 `json:"bbox_min"`
+	BBoxMax   [3]float64 `json:"bbox_max"`
 }
 
 func ConnectDB() (*pgxpool.Pool, error) {
 	err := godotenv.Load()
-    if err != nil {
-        fmt.Errorf("Ошибка при загрузке файла .env: %v", err)
-    }
+	if err != nil {
+		fmt.Printf("Ошибка при загрузке файла .env: %v\n", err)
+	}
 
 	host := os.Getenv("HOST")
 	port := os.Getenv("PORT")
@@ -46,25 +87,179 @@ func ConnectDB() (*pgxpool.Pool, error) {
 	return pool, nil
 }
 
-func SaveMeshObject(db *pgxpool.Pool, name string, data []byte) (int, error) {
+// SaveMeshObject creates a mesh object with its first revision and the
+// first representation of it, and returns the IDs of the mesh and the
+// representation. Both rows point to the same blob.
+func SaveMeshObject(db *pgxpool.Pool, m *MeshObject, rev *MeshRevision) (int, int, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	query := `INSERT INTO mesh_objects (name, owner_id, blob_key, blob_size, blob_sha256, format, quad, face_limit,
+			detected_format, geometry)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`
+	err = tx.QueryRow(ctx, query, m.Name, m.OwnerID, m.Blob.Key, m.Blob.Size, m.Blob.SHA256, m.Format, m.Quad,
+		m.FaceLimit, m.DetectedFormat, m.Geometry).Scan(&id)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := insertMeshRevision(ctx, tx, id, rev); err != nil {
+		return 0, 0, err
+	}
+	representationID, err := insertMeshRepresentation(ctx, tx, &MeshRepresentation{
+		RevisionID:     rev.ID,
+		Format:         m.Format,
+		Quad:           m.Quad,
+		FaceLimit:      m.FaceLimit,
+		Blob:           m.Blob,
+		DetectedFormat: m.DetectedFormat,
+		Geometry:       m.Geometry,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = tx.Exec(ctx, `UPDATE mesh_objects SET current_revision_id = $2 WHERE id = $1`, id, rev.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, err
+	}
+	return id, representationID, nil
+}
+
+// SaveMeshRepresentation adds a representation to the revision
+// rep.RevisionID.
+func SaveMeshRepresentation(db *pgxpool.Pool, rep *MeshRepresentation) (int, error) {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	id, err := insertMeshRepresentation(ctx, tx, rep)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// insertMeshRepresentation stores rep in its revision and fills in the mesh
+// ID from it.
+func insertMeshRepresentation(ctx context.Context, tx pgx.Tx, rep *MeshRepresentation) (int, error) {
 	var id int
-	query := `INSERT INTO mesh_objects (name, data) VALUES ($1, $2) RETURNING id`
-	err := db.QueryRow(context.Background(), query, name, data).Scan(&id)
+	query := `INSERT INTO mesh_representations (mesh_id, revision_id, format, quad, face_limit, blob_key, blob_size,
+			blob_sha256, detected_format, geometry)
+		SELECT r.mesh_id, r.id, $2, $3, $4, $5, $6, $7, $8, $9 FROM mesh_revisions r WHERE r.id = $1
+		RETURNING id, mesh_id`
+	err := tx.QueryRow(ctx, query, rep.RevisionID, rep.Format, rep.Quad, rep.FaceLimit, rep.Blob.Key,
+		rep.Blob.Size, rep.Blob.SHA256, rep.DetectedFormat, rep.Geometry).Scan(&id, &rep.MeshID)
 	if err != nil {
 		return 0, err
 	}
+	rep.ID = id
 	return id, nil
 }
 
+func ListMeshRepresentations(db *pgxpool.Pool, meshID int) ([]MeshRepresentation, error) {
+	query := `SELECT id, mesh_id, COALESCE(revision_id, 0), format, quad, face_limit, COALESCE(blob_key, ''),
+			blob_size, blob_sha256, detected_format, geometry, created_at
+		FROM mesh_representations
+		WHERE mesh_id = $1 AND revision_id = (SELECT current_revision_id FROM mesh_objects WHERE id = $1)
+		ORDER BY id`
+	rows, err := db.Query(context.Background(), query, meshID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var representations []MeshRepresentation
+	for rows.Next() {
+		var rep MeshRepresentation
+		err := rows.Scan(&rep.ID, &rep.MeshID, &rep.RevisionID, &rep.Format, &rep.Quad, &rep.FaceLimit,
+			&rep.Blob.Key, &rep.Blob.Size, &rep.Blob.SHA256, &rep.DetectedFormat, &rep.Geometry, &rep.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		representations = append(representations, rep)
+	}
+	return representations, rows.Err()
+}
+
+// GetMeshRepresentation returns a representation of a revision of a mesh:
+// the latest one in format, or the first one when format is empty. Revision
+// 0 is the current revision.
+func GetMeshRepresentation(db *pgxpool.Pool, meshID int, revision int, format string) (*MeshRepresentation, error) {
+	query := `SELECT p.id, p.mesh_id, p.revision_id, p.format, p.quad, p.face_limit, COALESCE(p.blob_key, ''),
+			CASE WHEN p.blob_key IS NULL THEN COALESCE(octet_length(p.data), 0) ELSE p.blob_size END,
+			p.blob_sha256, p.detected_format, p.geometry, p.created_at
+		FROM mesh_representations p JOIN mesh_revisions r ON r.id = p.revision_id
+		WHERE p.mesh_id = $1
+			AND CASE WHEN $2 = 0 THEN r.id = (SELECT current_revision_id FROM mesh_objects WHERE id = $1)
+				ELSE r.number = $2 END
+			AND ($3 = '' OR p.format = $3)
+		ORDER BY CASE WHEN $3 = '' THEN p.id ELSE -p.id END
+		LIMIT 1`
+	row := db.QueryRow(context.Background(), query, meshID, revision, format)
+
+	var rep MeshRepresentation
+	err := row.Scan(&rep.ID, &rep.MeshID, &rep.RevisionID, &rep.Format, &rep.Quad, &rep.FaceLimit,
+		&rep.Blob.Key, &rep.Blob.Size, &rep.Blob.SHA256, &rep.DetectedFormat, &rep.Geometry, &rep.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &rep, nil
+}
+
+func MeshObjectExists(db *pgxpool.Pool, id int) (bool, error) {
+	var exists bool
+	err := db.QueryRow(context.Background(), `SELECT EXISTS (SELECT 1 FROM mesh_objects WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists)
+	return exists, err
+}
+
 func GetMeshObjectByID(db *pgxpool.Pool, id int) (*MeshObject, error) {
-	query := `SELECT id, name, data, upload_time FROM mesh_objects WHERE id = $1`
+	query := `SELECT id, name, COALESCE(owner_id, 0),
+			COALESCE((SELECT number FROM mesh_revisions WHERE id = current_revision_id), 0),
+			COALESCE(blob_key, ''), CASE WHEN blob_key IS NULL THEN COALESCE(octet_length(data), 0) ELSE blob_size END,
+			blob_sha256, format, quad, face_limit, detected_format, geometry, upload_time
+		FROM mesh_objects WHERE id = $1 AND deleted_at IS NULL`
 	row := db.QueryRow(context.Background(), query, id)
 
 	var mesh MeshObject
-	err := row.Scan(&mesh.ID, &mesh.Name, &mesh.Data, &mesh.UploadTime)
+	err := row.Scan(&mesh.ID, &mesh.Name, &mesh.OwnerID, &mesh.CurrentRevision, &mesh.Blob.Key, &mesh.Blob.Size, &mesh.Blob.SHA256,
+		&mesh.Format, &mesh.Quad, &mesh.FaceLimit, &mesh.DetectedFormat, &mesh.Geometry, &mesh.UploadTime)
 	if err != nil {
 		return nil, err
 	}
 
 	return &mesh, nil
 }
+
+// GetMeshFileData returns the file of a mesh object, or of one of its
+// representations when representationID is set, that is still kept in the
+// database instead of the blob store. It is nil for rows moved to the store.
+func GetMeshFileData(db *pgxpool.Pool, meshID int, representationID int) ([]byte, error) {
+	query := `SELECT CASE WHEN blob_key IS NULL THEN data END FROM mesh_objects WHERE id = $1`
+	args := []any{meshID}
+	if representationID != 0 {
+		query = `SELECT CASE WHEN blob_key IS NULL THEN data END FROM mesh_representations
+			WHERE id = $1 AND mesh_id = $2`
+		args = []any{representationID, meshID}
+	}
+
+	var data []byte
+	if err := db.QueryRow(context.Background(), query, args...).Scan(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
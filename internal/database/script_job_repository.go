@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go-project/internal/blob"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ScriptJob is one run of the local neural network script. OwnerID owns
+// the mesh it produces; GenerationJobID is the local generation job it runs
+// for, if any. The source image and the photo live in the blob store; Image
+// and Photo are only filled for rows not moved there yet, and by the pool
+// while it runs the job.
+type ScriptJob struct {
+	ID              int
+	Status          string
+	Filename        string
+	ImageRef        blob.Ref
+	Image           []byte
+	OwnerID         int
+	GenerationJobID int
+	ExitCode        *int
+	Stdout          string
+	Stderr          string
+	MeshID          int
+	PhotoRef        blob.Ref
+	Photo           []byte
+	Error           string
+	CreatedAt       time.Time
+	StartedAt       *time.Time
+	FinishedAt      *time.Time
+}
+
+// CreateScriptJob stores a new job. Without ImageRef, Filename is a file on
+// the inference host; with it Filename only names the uploaded image.
+func CreateScriptJob(db *pgxpool.Pool, job *ScriptJob) (int, error) {
+	var id int
+	query := `INSERT INTO script_jobs (filename, source_image_key, source_image_size, source_image_sha256,
+			owner_id, generation_job_id)
+		VALUES ($1, NULLIF($2, ''), $3, $4, NULLIF($5, 0), NULLIF($6, 0)) RETURNING id`
+	err := db.QueryRow(context.Background(), query, job.Filename, job.ImageRef.Key, job.ImageRef.Size,
+		job.ImageRef.SHA256, job.OwnerID, job.GenerationJobID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func GetScriptJobByID(db *pgxpool.Pool, id int) (*ScriptJob, error) {
+	query := `SELECT id, status, filename,
+			COALESCE(source_image_key, ''), source_image_size, source_image_sha256,
+			CASE WHEN source_image_key IS NULL THEN source_image END,
+			COALESCE(owner_id, 0), COALESCE(generation_job_id, 0), exit_code, stdout, stderr, COALESCE(mesh_id, 0),
+			COALESCE(photo_key, ''), photo_size, photo_sha256, CASE WHEN photo_key IS NULL THEN photo END,
+			error, created_at, started_at, finished_at
+		FROM script_jobs WHERE id = $1`
+	var job ScriptJob
+	err := db.QueryRow(context.Background(), query, id).Scan(&job.ID, &job.Status, &job.Filename,
+		&job.ImageRef.Key, &job.ImageRef.Size, &job.ImageRef.SHA256, &job.Image,
+		&job.OwnerID, &job.GenerationJobID, &job.ExitCode, &job.Stdout, &job.Stderr, &job.MeshID,
+		&job.PhotoRef.Key, &job.PhotoRef.Size, &job.PhotoRef.SHA256, &job.Photo,
+		&job.Error, &job.CreatedAt, &job.StartedAt, &job.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func StartScriptJob(db *pgxpool.Pool, id int) error {
+	query := `UPDATE script_jobs SET status = 'running', started_at = NOW() WHERE id = $1`
+	_, err := db.Exec(context.Background(), query, id)
+	return err
+}
+
+// FinishScriptJob stores the final status, the script output and the
+// collected artifacts of a job.
+func FinishScriptJob(db *pgxpool.Pool, job *ScriptJob) error {
+	query := `UPDATE script_jobs
+		SET status = $2, exit_code = $3, stdout = $4, stderr = $5, mesh_id = NULLIF($6, 0),
+			photo_key = NULLIF($7, ''), photo_size = $8, photo_sha256 = $9, error = $10, finished_at = NOW()
+		WHERE id = $1`
+	_, err := db.Exec(context.Background(), query, job.ID, job.Status, job.ExitCode, job.Stdout,
+		job.Stderr, job.MeshID, job.PhotoRef.Key, job.PhotoRef.Size, job.PhotoRef.SHA256, job.Error)
+	return err
+}
+
+// FailUnfinishedScriptJobs marks jobs left queued or running by a previous
+// process as failed and returns them. Their runs were lost with that process.
+func FailUnfinishedScriptJobs(db *pgxpool.Pool, reason string) ([]ScriptJob, error) {
+	query := `UPDATE script_jobs SET status = 'failed', error = $1, finished_at = NOW()
+		WHERE status IN ('queued', 'running')
+		RETURNING id, status, filename, COALESCE(owner_id, 0), COALESCE(generation_job_id, 0), error`
+	rows, err := db.Query(context.Background(), query, reason)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var failed []ScriptJob
+	for rows.Next() {
+		var job ScriptJob
+		err := rows.Scan(&job.ID, &job.Status, &job.Filename, &job.OwnerID, &job.GenerationJobID, &job.Error)
+		if err != nil {
+			return nil, err
+		}
+		failed = append(failed, job)
+	}
+	return failed, rows.Err()
+}
+
+// CountScriptJobsAhead returns how many queued jobs were created before the
+// job.
+func CountScriptJobsAhead(db *pgxpool.Pool, id int) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM script_jobs WHERE status = 'queued' AND id < $1`
+	err := db.QueryRow(context.Background(), query, id).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// CreateUserSession stores a session of the user under the hash of its token.
+func CreateUserSession(db *pgxpool.Pool, userID int, tokenSHA256 string, expiresAt time.Time) error {
+	_, err := db.Exec(context.Background(), `INSERT INTO user_sessions (token_sha256, user_id, expires_at)
+		VALUES ($1, $2, $3)`, tokenSHA256, userID, expiresAt)
+	return err
+}
+
+// GetSessionUserID returns the user of an unexpired session, or
+// pgx.ErrNoRows when there is none with that token hash.
+func GetSessionUserID(db *pgxpool.Pool, tokenSHA256 string) (int, error) {
+	var userID int
+	err := db.QueryRow(context.Background(), `SELECT user_id FROM user_sessions
+		WHERE token_sha256 = $1 AND expires_at > NOW()`, tokenSHA256).Scan(&userID)
+	return userID, err
+}
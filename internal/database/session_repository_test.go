@@ -0,0 +1,33 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestGetSessionUserIDSkipsExpiredSessions(t *testing.T) {
+	db := testDB(t)
+	userID := newTestUser(t, db, 0)
+	prefix := fmt.Sprintf("%064d", time.Now().UnixNano())[:56]
+
+	if err := CreateUserSession(db, userID, prefix+"live0000", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateUserSession(db, userID, prefix+"expired0", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := GetSessionUserID(db, prefix+"live0000"); err != nil || got != userID {
+		t.Errorf("live session returned user %d, %v, want %d", got, err, userID)
+	}
+	if _, err := GetSessionUserID(db, prefix+"expired0"); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("expired session returned %v, want pgx.ErrNoRows", err)
+	}
+	if _, err := GetSessionUserID(db, prefix+"unknown0"); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("unknown session returned %v, want pgx.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type WebhookSubscription struct {
+	ID        int
+	ClientID  string
+	URL       string
+	Secret    string
+	CreatedAt time.Time
+}
+
+type WebhookDelivery struct {
+	ID         int
+	JobID      int
+	URL        string
+	Event      string
+	Payload    string
+	Attempt    int
+	StatusCode int
+	Error      string
+	Delivered  bool
+	CreatedAt  time.Time
+}
+
+// CreateWebhookSubscription registers the callback URL of a new client. It
+// returns pgx.ErrNoRows when the client already has one.
+func CreateWebhookSubscription(db *pgxpool.Pool, clientID string, url string, secret string) (int, error) {
+	var id int
+	query := `INSERT INTO webhook_subscriptions (client_id, url, secret) VALUES ($1, $2, $3)
+		ON CONFLICT (client_id) DO NOTHING RETURNING id`
+	err := db.QueryRow(context.Background(), query, clientID, url, secret).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ReplaceWebhookSubscription sets a new callback URL and secret for a client
+// whose current secret is oldSecret. It returns pgx.ErrNoRows when the
+// secret does not match, for example because it was replaced concurrently.
+func ReplaceWebhookSubscription(db *pgxpool.Pool, clientID string, oldSecret string, url string, secret string) (int, error) {
+	var id int
+	query := `UPDATE webhook_subscriptions SET url = $3, secret = $4
+		WHERE client_id = $1 AND secret = $2 RETURNING id`
+	err := db.QueryRow(context.Background(), query, clientID, oldSecret, url, secret).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func GetWebhookSubscriptionByClientID(db *pgxpool.Pool, clientID string) (*WebhookSubscription, error) {
+	query := `SELECT id, client_id, url, secret, created_at FROM webhook_subscriptions WHERE client_id = $1`
+	row := db.QueryRow(context.Background(), query, clientID)
+
+	var sub WebhookSubscription
+	err := row.Scan(&sub.ID, &sub.ClientID, &sub.URL, &sub.Secret, &sub.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func ListWebhookDeliveries(db *pgxpool.Pool, jobID int) ([]WebhookDelivery, error) {
+	query := `SELECT id, job_id, url, event, payload, attempt, status_code, error, delivered, created_at
+		FROM webhook_deliveries WHERE job_id = $1 ORDER BY id`
+	rows, err := db.Query(context.Background(), query, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		err := rows.Scan(&d.ID, &d.JobID, &d.URL, &d.Event, &d.Payload, &d.Attempt,
+			&d.StatusCode, &d.Error, &d.Delivered, &d.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Webhook outbox statuses.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// WebhookMessage is a webhook waiting in the outbox. Attempts counts the
+// claims, including the current one.
+type WebhookMessage struct {
+	ID            int64
+	JobID         int
+	URL           string
+	Secret        string
+	Event         string
+	Payload       string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+}
+
+// CreateWebhookMessages queues messages in the given order.
+func CreateWebhookMessages(db *pgxpool.Pool, messages []WebhookMessage) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for i := range messages {
+		m := &messages[i]
+		query := `INSERT INTO webhook_outbox (job_id, url, secret, event, payload)
+			VALUES ($1, $2, $3, $4, $5) RETURNING id, status, next_attempt_at, created_at`
+		err := tx.QueryRow(ctx, query, m.JobID, m.URL, m.Secret, m.Event, m.Payload).Scan(&m.ID,
+			&m.Status, &m.NextAttemptAt, &m.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// ClaimWebhookMessage locks the oldest due message that has no earlier
+// pending message for the same job and URL, for lease. It returns nil when
+// there is nothing to send.
+func ClaimWebhookMessage(db *pgxpool.Pool, lease time.Duration) (*WebhookMessage, error) {
+	query := `UPDATE webhook_outbox
+		SET attempts = attempts + 1, locked_until = NOW() + make_interval(secs => $1)
+		WHERE id = (
+			SELECT o.id FROM webhook_outbox o
+			WHERE o.status = 'pending' AND o.next_attempt_at <= NOW()
+				AND (o.locked_until IS NULL OR o.locked_until < NOW())
+				AND NOT EXISTS (SELECT 1 FROM webhook_outbox p
+					WHERE p.job_id = o.job_id AND p.url = o.url AND p.status = 'pending' AND p.id < o.id)
+			ORDER BY o.id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, job_id, url, secret, event, payload, status, attempts, next_attempt_at, created_at`
+
+	var m WebhookMessage
+	err := db.QueryRow(context.Background(), query, lease.Seconds()).Scan(&m.ID, &m.JobID, &m.URL,
+		&m.Secret, &m.Event, &m.Payload, &m.Status, &m.Attempts, &m.NextAttemptAt, &m.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// FinishWebhookMessage logs the last attempt of m and sets its final status,
+// which lets the next message of the job go out.
+func FinishWebhookMessage(db *pgxpool.Pool, m *WebhookMessage, delivery *WebhookDelivery) error {
+	m.Status = WebhookFailed
+	if delivery.Delivered {
+		m.Status = WebhookDelivered
+	}
+	return updateWebhookMessage(db, m, delivery)
+}
+
+// RetryWebhookMessage logs a failed attempt of m and schedules the next one.
+func RetryWebhookMessage(db *pgxpool.Pool, m *WebhookMessage, delivery *WebhookDelivery, at time.Time) error {
+	m.NextAttemptAt = at
+	return updateWebhookMessage(db, m, delivery)
+}
+
+func updateWebhookMessage(db *pgxpool.Pool, m *WebhookMessage, delivery *WebhookDelivery) error {
+	ctx := context.Background()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO webhook_deliveries (job_id, url, event, payload, attempt, status_code, error, delivered)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	err = tx.QueryRow(ctx, query, delivery.JobID, delivery.URL, delivery.Event, delivery.Payload,
+		delivery.Attempt, delivery.StatusCode, delivery.Error, delivery.Delivered).Scan(&delivery.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `UPDATE webhook_outbox SET status = $2, next_attempt_at = $3, locked_until = NULL WHERE id = $1`
+	if _, err := tx.Exec(ctx, query, m.ID, m.Status, m.NextAttemptAt); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+
+	"go-project/internal/database"
+	"go-project/internal/provider"
+)
+
+// CacheKey identifies the result of an image or multiview request: a hash of
+// the uploaded image bytes and the conversion options. Text requests are not
+// cached, so the same prompt can produce different models.
+func (req Request) CacheKey() string {
+	h := sha256.New()
+	switch req.Mode {
+	case ModeImage, "":
+		writeField(h, ModeImage)
+		writeField(h, string(req.Image))
+	case ModeMultiview:
+		writeField(h, ModeMultiview)
+		for _, image := range req.Images {
+			writeField(h, image.View)
+			writeField(h, string(image.Data))
+		}
+	default:
+		return ""
+	}
+	for _, c := range req.Conversions {
+		writeField(h, c.Format)
+		writeField(h, strconv.FormatBool(c.Quad))
+		writeField(h, strconv.Itoa(c.FaceLimit))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// reuse turns job into a finished copy of cached. Both have the same cache
+// key, so their conversions match one to one.
+func reuse(job *database.GenerationJob, cached *database.GenerationJob) {
+	job.Status = StatusSucceeded
+	job.Stage = StageDone
+	job.Progress = 100
+	job.MeshID = cached.MeshID
+	job.CachedFromJobID = cached.ID
+
+	for i := range job.Conversions {
+		c := &job.Conversions[i]
+		for _, cachedConversion := range cached.Conversions {
+			if cachedConversion.Format == c.Format {
+				c.Status = provider.StatusSuccess
+				c.Progress = 100
+				c.ModelURL = cachedConversion.ModelURL
+				c.RepresentationID = cachedConversion.RepresentationID
+			}
+		}
+	}
+}
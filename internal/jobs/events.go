@@ -0,0 +1,102 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+
+	"go-project/internal/database"
+)
+
+const (
+	EventState    = "state"
+	EventProgress = "progress"
+)
+
+// Event is published for every status or stage change of a job (EventState)
+// and for every provider status received while polling (EventProgress).
+type Event struct {
+	Type            string    `json:"type"`
+	JobID           int       `json:"job_id"`
+	Status          string    `json:"status"`
+	Stage           string    `json:"stage"`
+	Backend         string    `json:"backend"`
+	Progress        int       `json:"progress"`
+	QueuingNum      int       `json:"queuing_num"`
+	RunningLeftTime int       `json:"running_left_time"`
+	MeshID          int       `json:"mesh_id,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	Time            time.Time `json:"time"`
+}
+
+func NewEvent(eventType string, job *database.GenerationJob) Event {
+	return Event{
+		Type:            eventType,
+		JobID:           job.ID,
+		Status:          job.Status,
+		Stage:           job.Stage,
+		Backend:         job.Backend,
+		Progress:        job.Progress,
+		QueuingNum:      job.QueuingNum,
+		RunningLeftTime: job.RunningLeftTime,
+		MeshID:          job.MeshID,
+		Error:           job.Error,
+		Time:            time.Now().UTC(),
+	}
+}
+
+// Final reports whether the job will not change any more.
+func (e Event) Final() bool {
+	return IsFinalStatus(e.Status)
+}
+
+func IsFinalStatus(status string) bool {
+	return status == StatusSucceeded || status == StatusFailed || status == StatusCancelled
+}
+
+// subscriberBuffer is how many events a slow subscriber may lag behind
+// before further events are dropped for it.
+const subscriberBuffer = 32
+
+// Broker fans job events out to in-process subscribers.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[int]map[chan Event]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: map[int]map[chan Event]struct{}{}}
+}
+
+// Subscribe returns the events of one job and a function that ends the
+// subscription.
+func (b *Broker) Subscribe(jobID int) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subs[jobID] == nil {
+		b.subs[jobID] = map[chan Event]struct{}{}
+	}
+	b.subs[jobID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[jobID], ch)
+		if len(b.subs[jobID]) == 0 {
+			delete(b.subs, jobID)
+		}
+	}
+}
+
+func (b *Broker) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[event.JobID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
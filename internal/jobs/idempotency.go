@@ -0,0 +1,53 @@
+package jobs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strconv"
+	"time"
+)
+
+// IdempotencyKeyTTL is how long an Idempotency-Key is remembered.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+
+// Fingerprint hashes everything that defines the generated result. The
+// callback secret is generated per request and therefore left out.
+func (req Request) Fingerprint() string {
+	h := sha256.New()
+	writeField(h, req.Mode)
+	writeField(h, req.Filename)
+	writeField(h, string(req.Image))
+	for _, image := range req.Images {
+		writeField(h, image.View)
+		writeField(h, image.Filename)
+		writeField(h, string(image.Data))
+	}
+	writeField(h, req.Prompt)
+	writeField(h, req.NegativePrompt)
+	for _, c := range req.Conversions {
+		writeField(h, c.Format)
+		writeField(h, strconv.FormatBool(c.Quad))
+		writeField(h, strconv.Itoa(c.FaceLimit))
+	}
+	writeField(h, strconv.Itoa(req.UserID))
+	writeField(h, req.ClientID)
+	writeField(h, strconv.FormatBool(req.Force))
+	writeField(h, strconv.FormatBool(req.Fallback))
+	writeField(h, strconv.Itoa(req.TargetMeshID))
+	writeField(h, req.CallbackURL)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeField writes a length-prefixed value, so adjacent fields cannot be
+// shifted into each other.
+func writeField(h hash.Hash, value string) {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(value)))
+	h.Write(length[:])
+	h.Write([]byte(value))
+}
@@ -0,0 +1,552 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go-project/internal/blob"
+	"go-project/internal/database"
+	"go-project/internal/mesh"
+	"go-project/internal/provider"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+const (
+	StageUpload   = "upload"
+	StageGenerate = "generate"
+	StageConvert  = "convert"
+	StageDownload = "download"
+	StageStore    = "store"
+	StageDone     = "done"
+)
+
+const (
+	ModeImage     = "image"
+	ModeText      = "text"
+	ModeMultiview = "multiview"
+)
+
+// Backends that run a job. Local jobs are image jobs run by the local script
+// through the function passed to SetLocalBackend.
+const (
+	BackendCloud = "cloud"
+	BackendLocal = "local"
+)
+
+// localTaskType and cachedTaskType are the credit_costs entries charged for
+// a local job and for a result served from the cache.
+const (
+	localTaskType  = "local_image_to_model"
+	cachedTaskType = "cached_result"
+)
+
+// lease is how long a claimed job stays locked without a heartbeat. Jobs of
+// a crashed process become claimable again once their lease runs out. The
+// worker renews it every heartbeatInterval, whatever the job is doing.
+const (
+	lease             = 60 * time.Second
+	heartbeatInterval = lease / 4
+)
+
+// maxAttempts is how many times a job is claimed before it is failed, so a
+// job that keeps crashing its worker does not run forever.
+const maxAttempts = 5
+
+const idlePollInterval = 5 * time.Second
+
+// ErrLocalUnsupported is returned for local jobs other than image jobs
+// creating a new mesh object.
+var ErrLocalUnsupported = errors.New("the local backend only generates new mesh objects from a single image")
+
+// ErrNoUser is returned for a job that costs credits but has no user to
+// charge them to.
+var ErrNoUser = errors.New("a job that costs credits needs a user")
+
+// Queue runs generation jobs stored in Postgres with a fixed number of workers.
+type Queue struct {
+	db       *pgxpool.Pool
+	provider provider.Provider
+	meshes   *mesh.Service
+	blobs    blob.Store
+	workers  int
+	wake     chan struct{}
+	events   *Broker
+
+	mu          sync.Mutex
+	running     map[int]context.CancelFunc
+	runLocal    map[int]int
+	onChange    []func(database.GenerationJob)
+	local       func(database.GenerationJob) (int, error)
+	cancelLocal func(scriptJobID int)
+}
+
+// Request describes a new generation job. Image jobs generate the model from
+// Image, text jobs from Prompt and multiview jobs from Images. The model is
+// converted to every format in Conversions; the first one becomes the mesh
+// object. Force skips the result cache. The credits of the job are reserved
+// from the balance of UserID. Backend local runs an image job on the local
+// script, giving FallbackReason when it was not asked for. Fallback lets a
+// failed cloud job be handed over to the local script. A TargetMeshID stores
+// the result as a new revision of that mesh object instead of creating a new
+// one.
+type Request struct {
+	Mode           string
+	Filename       string
+	Image          []byte
+	Images         []Image
+	Prompt         string
+	NegativePrompt string
+	Conversions    []provider.Input
+	Force          bool
+	Backend        string
+	FallbackReason string
+	Fallback       bool
+	TargetMeshID   int
+	UserID         int
+	ClientID       string
+	CallbackURL    string
+	CallbackSecret string
+}
+
+// Image is a photo of the object taken from one of provider.Views.
+type Image struct {
+	View     string
+	Filename string
+	Data     []byte
+}
+
+func NewQueue(db *pgxpool.Pool, p provider.Provider, meshes *mesh.Service, blobs blob.Store, workers int) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Queue{
+		db:       db,
+		provider: p,
+		meshes:   meshes,
+		blobs:    blobs,
+		workers:  workers,
+		wake:     make(chan struct{}, workers),
+		events:   NewBroker(),
+		running:  map[int]context.CancelFunc{},
+		runLocal: map[int]int{},
+	}
+}
+
+// Start launches the workers. Jobs left in flight by a previous run are
+// claimed again and resume from their last stored stage. Local jobs cannot
+// be resumed: those whose script job was never created are failed here, the
+// others when the script pool fails their script job.
+func (q *Queue) Start(ctx context.Context) {
+	orphaned, err := database.FailOrphanedLocalGenerationJobs(q.db, "interrupted by server restart")
+	if err != nil {
+		log.Printf("Failed to clean up orphaned local jobs: %v", err)
+	} else if len(orphaned) > 0 {
+		log.Printf("Marked %d local jobs without a script job as failed", len(orphaned))
+	}
+	for i := range orphaned {
+		q.notify(&orphaned[i])
+	}
+
+	inFlight, err := database.CountInFlightGenerationJobs(q.db)
+	if err != nil {
+		log.Printf("Failed to count in-flight generation jobs: %v", err)
+	} else if inFlight > 0 {
+		log.Printf("Resuming %d in-flight generation jobs", inFlight)
+	}
+
+	for i := 0; i < q.workers; i++ {
+		go q.work(ctx, i)
+	}
+}
+
+// OnChange registers fn to be called whenever a job changes its status or
+// stage. It must be called before Start.
+func (q *Queue) OnChange(fn func(job database.GenerationJob)) {
+	q.onChange = append(q.onChange, fn)
+}
+
+// SetLocalBackend sets the function that starts the script job of a local
+// job and returns its ID, and the one that stops a script job when its job
+// is cancelled. Without it only cloud jobs run. It must be called before
+// Start.
+func (q *Queue) SetLocalBackend(start func(job database.GenerationJob) (int, error), cancel func(scriptJobID int)) {
+	q.local = start
+	q.cancelLocal = cancel
+}
+
+// Subscribe streams the events of a job running in this process.
+func (q *Queue) Subscribe(jobID int) (<-chan Event, func()) {
+	return q.events.Subscribe(jobID)
+}
+
+func (q *Queue) notify(job *database.GenerationJob) {
+	stage := job.Stage
+	if job.Status == StatusQueued {
+		stage = StatusQueued
+	}
+	if err := database.RecordGenerationState(q.db, job, stage, IsFinalStatus(job.Status)); err != nil {
+		log.Printf("Failed to record generation of job %d: %v", job.ID, err)
+	}
+
+	if IsFinalStatus(job.Status) && job.CreditsState == database.CreditsReserved {
+		if err := database.FinishJobCredits(q.db, job.ID, job.Status == StatusSucceeded); err != nil {
+			log.Printf("Failed to settle credits of job %d: %v", job.ID, err)
+		}
+	}
+
+	q.events.Publish(NewEvent(EventState, job))
+	for _, fn := range q.onChange {
+		fn(*job)
+	}
+}
+
+// Enqueue stores a new job. Unless req.Force is set, a request whose result
+// is already cached gets a job that has succeeded right away.
+func (q *Queue) Enqueue(req Request) (*database.GenerationJob, error) {
+	job, err := q.newJob(req)
+	if err != nil {
+		return nil, err
+	}
+	job.ID, err = database.CreateGenerationJob(q.db, job)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.started(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// EnqueueIdempotent is Enqueue for a request carrying an Idempotency-Key.
+// The first request with a key creates the job; repeats of the same request
+// within IdempotencyKeyTTL return that job with replayed set. Reusing a key
+// for a different request fails with ErrIdempotencyKeyReused.
+func (q *Queue) EnqueueIdempotent(key string, req Request) (job *database.GenerationJob, replayed bool, err error) {
+	job, err = q.newJob(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	idempotencyKey := &database.IdempotencyKey{
+		ClientID:    req.ClientID,
+		Key:         key,
+		RequestHash: req.Fingerprint(),
+		ExpiresAt:   time.Now().Add(IdempotencyKeyTTL),
+	}
+	stored, err := database.CreateIdempotentGenerationJob(q.db, job, idempotencyKey)
+	if err != nil {
+		return nil, false, err
+	}
+	if stored != nil {
+		if stored.RequestHash != idempotencyKey.RequestHash {
+			return nil, false, ErrIdempotencyKeyReused
+		}
+		job, err = database.GetGenerationJobByID(q.db, stored.JobID)
+		if err != nil {
+			return nil, false, err
+		}
+		return job, true, nil
+	}
+
+	if err := q.started(job); err != nil {
+		return nil, false, err
+	}
+	return job, false, nil
+}
+
+func (q *Queue) newJob(req Request) (*database.GenerationJob, error) {
+	if len(req.Conversions) == 0 {
+		return nil, fmt.Errorf("at least one conversion is required")
+	}
+	if req.Mode == "" {
+		req.Mode = ModeImage
+	}
+	if req.Backend == "" {
+		req.Backend = BackendCloud
+	}
+	if req.Backend == BackendLocal {
+		if req.Mode != ModeImage || req.TargetMeshID != 0 {
+			return nil, ErrLocalUnsupported
+		}
+		if q.local == nil {
+			return nil, fmt.Errorf("the local backend is not configured")
+		}
+	}
+
+	primary := req.Conversions[0]
+	job := &database.GenerationJob{
+		Status:         StatusQueued,
+		Stage:          StageUpload,
+		Mode:           req.Mode,
+		Filename:       req.Filename,
+		SourceImage:    req.Image,
+		Prompt:         req.Prompt,
+		NegativePrompt: req.NegativePrompt,
+		Format:         primary.Format,
+		Quad:           primary.Quad,
+		FaceLimit:      primary.FaceLimit,
+		ClientID:       req.ClientID,
+		UserID:         req.UserID,
+		Fallback:       req.Fallback,
+		TargetMeshID:   req.TargetMeshID,
+		CallbackURL:    req.CallbackURL,
+		CallbackSecret: req.CallbackSecret,
+		Backend:        req.Backend,
+		FallbackReason: req.FallbackReason,
+	}
+	if job.Backend == BackendLocal {
+		// The script makes a single USD file and takes no conversion options.
+		job.Status = StatusRunning
+		job.Stage = StageGenerate
+		job.Format, job.Quad, job.FaceLimit = "USD", false, 0
+		if err := q.saveImages(job); err != nil {
+			return nil, err
+		}
+		if err := q.reserveCredits(job, localTaskType, 0); err != nil {
+			return nil, err
+		}
+		return job, nil
+	}
+	for _, conversion := range req.Conversions {
+		job.Conversions = append(job.Conversions, database.JobConversion{
+			Format:    conversion.Format,
+			Quad:      conversion.Quad,
+			FaceLimit: conversion.FaceLimit,
+			Status:    provider.StatusQueued,
+		})
+	}
+	for _, image := range req.Images {
+		job.Images = append(job.Images, database.JobImage{
+			View:     image.View,
+			Filename: image.Filename,
+			Data:     image.Data,
+		})
+	}
+	if err := q.saveImages(job); err != nil {
+		return nil, err
+	}
+
+	job.CacheKey = req.CacheKey()
+	// A cached result belongs to another mesh object, so jobs that add a
+	// revision always generate. Only results of the same user are reused and
+	// the hit is charged as cachedTaskType.
+	if job.CacheKey != "" && !req.Force && req.TargetMeshID == 0 && req.UserID != 0 {
+		cached, err := database.FindCachedGenerationJob(q.db, job.CacheKey, req.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up cached result: %v", err)
+		}
+		if cached != nil {
+			reuse(job, cached)
+			if err := q.reserveCredits(job, cachedTaskType, 0); err != nil {
+				return nil, err
+			}
+			return job, nil
+		}
+	}
+
+	if err := q.reserveCredits(job, taskType(job.Mode), len(job.Conversions)); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// saveImages stores the source images of a new job in the blob store; the
+// job row only keeps their references.
+func (q *Queue) saveImages(job *database.GenerationJob) error {
+	ctx := context.Background()
+	if job.SourceImage != nil {
+		ref, err := blob.Save(ctx, q.blobs, job.SourceImage)
+		if err != nil {
+			return fmt.Errorf("failed to store source image: %v", err)
+		}
+		job.SourceImageRef = ref
+	}
+	for i := range job.Images {
+		image := &job.Images[i]
+		ref, err := blob.Save(ctx, q.blobs, image.Data)
+		if err != nil {
+			return fmt.Errorf("failed to store %s view: %v", image.View, err)
+		}
+		image.Ref = ref
+		image.Size = ref.Size
+	}
+	return nil
+}
+
+// reserveCredits sets the credits the job takes from its user: the cost of
+// task plus that of the conversions. Only free jobs may run without a user.
+func (q *Queue) reserveCredits(job *database.GenerationJob, task string, conversions int) error {
+	credits, err := q.cost(task, conversions)
+	if err != nil {
+		return err
+	}
+	if job.UserID == 0 {
+		if credits > 0 {
+			return ErrNoUser
+		}
+		return nil
+	}
+	job.CreditsReserved = credits
+	if job.CreditsReserved > 0 {
+		job.CreditsState = database.CreditsReserved
+	}
+	return nil
+}
+
+func (q *Queue) cost(task string, conversions int) (int, error) {
+	costs, err := database.GetCreditCosts(q.db)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load credit costs: %v", err)
+	}
+	return costs[task] + costs[provider.TaskConvertModel]*conversions, nil
+}
+
+// taskType returns the provider task type that generates the model of a job
+// in mode.
+func taskType(mode string) string {
+	switch mode {
+	case ModeText:
+		return provider.TaskTextToModel
+	case ModeMultiview:
+		return provider.TaskMultiviewToModel
+	}
+	return provider.TaskImageToModel
+}
+
+// started announces a new job and wakes an idle worker. A local job is
+// handed to the local script instead; when that fails the job fails too.
+func (q *Queue) started(job *database.GenerationJob) error {
+	q.notify(job)
+	if job.Backend == BackendLocal {
+		return q.runLocally(job)
+	}
+	if job.Status != StatusQueued {
+		return nil
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// runLocally starts the script job of a running local job. The job is
+// finished by FinishLocal once the script job is done.
+func (q *Queue) runLocally(job *database.GenerationJob) error {
+	scriptJobID, err := q.local(*job)
+	if err != nil {
+		q.FinishLocal(database.GenerationJob{ID: job.ID, Status: StatusFailed, Stage: job.Stage,
+			Error: fmt.Sprintf("failed to start local script: %v", err)})
+		return err
+	}
+	job.ScriptJobID = scriptJobID
+	q.trackLocal(job.ID, scriptJobID)
+	if err := database.SetFallbackScriptJob(q.db, job.ID, scriptJobID); err != nil {
+		log.Printf("Failed to link script job %d to job %d: %v", scriptJobID, job.ID, err)
+	}
+	log.Printf("Job %d is run by local script job %d", job.ID, scriptJobID)
+	return nil
+}
+
+// FinishLocal stores the outcome of a local job, given as its status, stage,
+// error and mesh, and notifies the job listeners. Jobs that are no longer
+// running, such as cancelled ones, are left alone.
+func (q *Queue) FinishLocal(outcome database.GenerationJob) {
+	q.trackLocal(outcome.ID, 0)
+	job, err := database.FinishLocalGenerationJob(q.db, &outcome)
+	if errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Local job %d is no longer running, dropping its result", outcome.ID)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to store result of local job %d: %v", outcome.ID, err)
+		return
+	}
+	q.notify(job)
+}
+
+// Cancel stops a queued or running job. A cloud job running in another
+// process notices the cancellation on its next heartbeat; the script of a
+// local job is stopped when it runs in this process.
+func (q *Queue) Cancel(id int) (bool, error) {
+	cancelled, err := database.CancelGenerationJob(q.db, id)
+	if err != nil || !cancelled {
+		return cancelled, err
+	}
+
+	q.mu.Lock()
+	if cancel, ok := q.running[id]; ok {
+		cancel()
+	}
+	scriptJobID, local := q.runLocal[id]
+	delete(q.runLocal, id)
+	q.mu.Unlock()
+	if local {
+		q.cancelLocal(scriptJobID)
+	}
+
+	if job, err := database.GetGenerationJobByID(q.db, id); err == nil {
+		q.notify(job)
+	}
+	return true, nil
+}
+
+func (q *Queue) track(id int, cancel context.CancelFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if cancel == nil {
+		delete(q.running, id)
+		return
+	}
+	q.running[id] = cancel
+}
+
+// trackLocal remembers the script job running a local job, or forgets it
+// when scriptJobID is 0.
+func (q *Queue) trackLocal(id int, scriptJobID int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if scriptJobID == 0 {
+		delete(q.runLocal, id)
+		return
+	}
+	q.runLocal[id] = scriptJobID
+}
+
+func (q *Queue) work(ctx context.Context, worker int) {
+	for {
+		job, err := database.ClaimGenerationJob(q.db, lease)
+		if err != nil {
+			log.Printf("Worker %d: failed to claim generation job: %v", worker, err)
+		}
+		if job != nil {
+			log.Printf("Worker %d: claimed job %d at stage %s", worker, job.ID, job.Stage)
+			q.run(ctx, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-time.After(idlePollInterval):
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go-project/internal/database"
+	"go-project/internal/provider"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testQueue returns a queue on TEST_DATABASE_URL, which must name a scratch
+// database with the schema and all migrations applied. Jobs left queued or
+// running there are failed first, so the tests claim their own jobs.
+func testQueue(t *testing.T) *Queue {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	query := `UPDATE generation_jobs SET status = 'failed', error = 'left over by a test'
+		WHERE status IN ('queued', 'running')`
+	if _, err := db.Exec(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+	return NewQueue(db, provider.NewFake(), nil, nil, 1)
+}
+
+// newPaidJob creates a user with 100 credits and a queued cloud job that
+// reserves 30 of them.
+func newPaidJob(t *testing.T, q *Queue) (userID int, job *database.GenerationJob) {
+	userID, err := database.CreateUser(q.db, fmt.Sprintf("test-%d", time.Now().UnixNano()), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.GrantCredits(q.db, userID, 100, "test credits"); err != nil {
+		t.Fatal(err)
+	}
+
+	job = &database.GenerationJob{Status: StatusQueued, Stage: StageUpload, Mode: ModeText, Prompt: "a chair",
+		Format: "GLB", Backend: BackendCloud, UserID: userID, CreditsReserved: 30,
+		CreditsState: database.CreditsReserved}
+	if _, err := database.CreateGenerationJob(q.db, job); err != nil {
+		t.Fatal(err)
+	}
+	return userID, job
+}
+
+func checkRefunded(t *testing.T, q *Queue, userID int, jobID int) {
+	t.Helper()
+	if balance, err := database.GetCreditBalance(q.db, userID); err != nil || balance != 100 {
+		t.Errorf("balance is %d, %v, want 100", balance, err)
+	}
+	job, err := database.GetGenerationJobByID(q.db, jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.CreditsState != database.CreditsRefunded {
+		t.Errorf("credits state is %q, want %q", job.CreditsState, database.CreditsRefunded)
+	}
+}
+
+func TestRunFailsJobAfterMaxAttempts(t *testing.T) {
+	q := testQueue(t)
+	userID, job := newPaidJob(t, q)
+
+	// Every earlier claim crashed its worker.
+	query := `UPDATE generation_jobs SET attempts = $2 WHERE id = $1`
+	if _, err := q.db.Exec(context.Background(), query, job.ID, maxAttempts); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := database.ClaimGenerationJob(q.db, lease)
+	if err != nil || claimed == nil || claimed.ID != job.ID {
+		t.Fatalf("claim returned %v, %v", claimed, err)
+	}
+
+	q.run(context.Background(), claimed)
+
+	stored, err := database.GetGenerationJobByID(q.db, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != StatusFailed || !strings.Contains(stored.Error, "attempts") {
+		t.Errorf("job has status %s and error %q", stored.Status, stored.Error)
+	}
+	checkRefunded(t, q, userID, job.ID)
+}
+
+func TestCancelRefundsCredits(t *testing.T) {
+	q := testQueue(t)
+	userID, job := newPaidJob(t, q)
+
+	if cancelled, err := q.Cancel(job.ID); err != nil || !cancelled {
+		t.Fatalf("Cancel returned %v, %v", cancelled, err)
+	}
+	if cancelled, err := q.Cancel(job.ID); err != nil || cancelled {
+		t.Errorf("second Cancel returned %v, %v", cancelled, err)
+	}
+
+	stored, err := database.GetGenerationJobByID(q.db, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != StatusCancelled {
+		t.Errorf("job has status %s", stored.Status)
+	}
+	checkRefunded(t, q, userID, job.ID)
+}
@@ -0,0 +1,527 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go-project/internal/blob"
+	"go-project/internal/database"
+	"go-project/internal/mesh"
+	"go-project/internal/provider"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// jobRun is a single execution of a claimed job. Conversions are polled in
+// parallel, so every change of job goes through update.
+type jobRun struct {
+	q      *Queue
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	job *database.GenerationJob
+}
+
+// run drives the job through the remaining stages. Provider task IDs are
+// stored as soon as they are known, so a resumed job continues polling the
+// existing tasks instead of paying for new ones.
+func (q *Queue) run(ctx context.Context, job *database.GenerationJob) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	q.track(job.ID, cancel)
+	defer q.track(job.ID, nil)
+
+	r := &jobRun{q: q, ctx: jobCtx, cancel: cancel, job: job}
+	if job.Attempts > maxAttempts {
+		log.Printf("Job %d failed after %d attempts", job.ID, maxAttempts)
+		r.update(func(job *database.GenerationJob) bool {
+			job.Status = StatusFailed
+			job.Error = fmt.Sprintf("gave up after %d attempts", maxAttempts)
+			return true
+		})
+		return
+	}
+	go r.heartbeat()
+	r.update(func(job *database.GenerationJob) bool { return true })
+
+	if err := r.advance(); err != nil {
+		if ctx.Err() != nil {
+			// Shutting down: leave the job running so it resumes after restart.
+			log.Printf("Job %d interrupted at stage %s", job.ID, job.Stage)
+			return
+		}
+		if jobCtx.Err() != nil {
+			log.Printf("Job %d stopped at stage %s: cancelled or claimed by another worker", job.ID, job.Stage)
+			return
+		}
+		log.Printf("Job %d failed at stage %s: %v", job.ID, job.Stage, err)
+		if r.fallBack(err) {
+			return
+		}
+		r.update(func(job *database.GenerationJob) bool {
+			job.Status = StatusFailed
+			job.Error = err.Error()
+			return true
+		})
+		return
+	}
+
+	r.update(func(job *database.GenerationJob) bool {
+		job.Status = StatusSucceeded
+		job.Stage = StageDone
+		job.Progress = 100
+		return true
+	})
+	log.Printf("Job %d finished, mesh ID %d", job.ID, job.MeshID)
+}
+
+// fallBack moves a failed image job that allows it to the local backend and
+// reports whether it did. The job keeps running there and is charged the
+// local price.
+func (r *jobRun) fallBack(cause error) bool {
+	job := r.job
+	if !job.Fallback || job.Mode != ModeImage || job.TargetMeshID != 0 || r.q.local == nil {
+		return false
+	}
+	credits, err := r.q.cost(localTaskType, 0)
+	if err != nil {
+		log.Printf("Failed to price local fallback of job %d: %v", job.ID, err)
+		return false
+	}
+
+	stored := r.update(func(job *database.GenerationJob) bool {
+		job.Backend = BackendLocal
+		job.FallbackReason = cause.Error()
+		job.Stage = StageGenerate
+		job.Progress = 0
+		job.QueuingNum = 0
+		job.RunningLeftTime = 0
+		return true
+	})
+	if !stored {
+		if r.ctx.Err() != nil {
+			// Cancelled or claimed by another worker.
+			return true
+		}
+		job.Backend, job.FallbackReason = BackendCloud, ""
+		return false
+	}
+	log.Printf("Job %d continues on the local backend", job.ID)
+
+	if job.CreditsState == database.CreditsReserved {
+		description := fmt.Sprintf("refunded the difference to %d credits, finished by the local backend", credits)
+		if err := database.ReduceJobCredits(r.q.db, job.ID, credits, description); err != nil {
+			log.Printf("Failed to reduce credits of job %d: %v", job.ID, err)
+		}
+	}
+	r.q.runLocally(job)
+	return true
+}
+
+func (r *jobRun) advance() error {
+	job := r.job
+
+	if job.GenerateTaskID == "" {
+		task, err := r.generationTask()
+		if err != nil {
+			return err
+		}
+
+		taskID, err := r.q.provider.CreateTask(r.ctx, task)
+		if err != nil {
+			return fmt.Errorf("failed to create %s task: %w", task.Type, err)
+		}
+		r.update(func(job *database.GenerationJob) bool {
+			job.ImageToken = task.FileToken
+			job.GenerateTaskID = taskID
+			return false
+		})
+	}
+
+	if job.Stage == StageUpload || job.Stage == StageGenerate {
+		r.setStage(StageGenerate)
+		status, err := provider.Poll(r.ctx, r.q.provider, job.GenerateTaskID, provider.DefaultPollOptions,
+			func(status *provider.TaskStatus) {
+				r.progress(status, status.Progress)
+			})
+		if err != nil {
+			return fmt.Errorf("failed to poll task: %w", err)
+		}
+		r.saveModel(status.ModelURL)
+	}
+
+	if job.Stage == StageGenerate || job.Stage == StageConvert {
+		r.setStage(StageConvert)
+		if err := r.convertAll(); err != nil {
+			return err
+		}
+	}
+
+	r.setStage(StageDownload)
+	files := make([][]byte, len(job.Conversions))
+	for i, c := range job.Conversions {
+		if c.RepresentationID != 0 {
+			continue
+		}
+		data, err := r.q.provider.FetchResult(r.ctx, c.ModelURL)
+		if err != nil {
+			return fmt.Errorf("failed to download %s file: %w", c.Format, err)
+		}
+		files[i] = data
+	}
+
+	r.setStage(StageStore)
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	return r.store(files)
+}
+
+// generationTask builds the provider task of the job, uploading the source
+// images first for image and multiview jobs.
+func (r *jobRun) generationTask() (provider.Task, error) {
+	job := r.job
+	switch job.Mode {
+	case ModeText:
+		return provider.Task{
+			Type:           provider.TaskTextToModel,
+			Prompt:         job.Prompt,
+			NegativePrompt: job.NegativePrompt,
+		}, nil
+	case ModeMultiview:
+		return r.multiviewTask()
+	}
+
+	r.setStage(StageUpload)
+	image, err := r.load(job.SourceImageRef, job.SourceImage)
+	if err != nil {
+		return provider.Task{}, fmt.Errorf("failed to load source image: %v", err)
+	}
+	imageToken, err := r.q.provider.Upload(r.ctx, job.Filename, image)
+	if err != nil {
+		return provider.Task{}, fmt.Errorf("failed to upload file: %w", err)
+	}
+	return provider.Task{
+		Type:      provider.TaskImageToModel,
+		FileType:  "jpg",
+		FileToken: imageToken,
+	}, nil
+}
+
+// multiviewTask uploads every image that has no file token yet. Tokens are
+// stored right away, so a resumed job does not upload the images again.
+func (r *jobRun) multiviewTask() (provider.Task, error) {
+	r.setStage(StageUpload)
+
+	task := provider.Task{
+		Type:  provider.TaskMultiviewToModel,
+		Files: make([]provider.TaskFile, len(provider.Views)),
+	}
+	for i := range r.job.Images {
+		image := &r.job.Images[i]
+		if image.ImageToken == "" {
+			data, err := r.load(image.Ref, image.Data)
+			if err != nil {
+				return provider.Task{}, fmt.Errorf("failed to load %s view: %v", image.View, err)
+			}
+			token, err := r.q.provider.Upload(r.ctx, image.Filename, data)
+			if err != nil {
+				return provider.Task{}, fmt.Errorf("failed to upload %s view: %w", image.View, err)
+			}
+			image.ImageToken = token
+			if err := database.UpdateJobImageToken(r.q.db, image, r.job.Attempts); err != nil {
+				log.Printf("Failed to store %s image token of job %d: %v", image.View, r.job.ID, err)
+			}
+		}
+
+		for j, view := range provider.Views {
+			if view == image.View {
+				task.Files[j] = provider.TaskFile{FileType: "jpg", FileToken: image.ImageToken}
+			}
+		}
+	}
+	return task, nil
+}
+
+// load returns the blob of ref, or data for rows not moved to the blob
+// store yet.
+func (r *jobRun) load(ref blob.Ref, data []byte) ([]byte, error) {
+	if ref.Key == "" {
+		return data, nil
+	}
+	return blob.Load(r.ctx, r.q.blobs, ref)
+}
+
+// saveModel keeps the generated model before conversion with the generation
+// record. It is not needed to finish the job, so failures are only logged.
+func (r *jobRun) saveModel(modelURL string) {
+	if modelURL == "" {
+		return
+	}
+	var ref blob.Ref
+	model, err := r.q.provider.FetchResult(r.ctx, modelURL)
+	if err != nil {
+		log.Printf("Failed to download generated model of job %d: %v", r.job.ID, err)
+	} else if ref, err = blob.Save(r.ctx, r.q.blobs, model); err != nil {
+		log.Printf("Failed to store generated model of job %d: %v", r.job.ID, err)
+	}
+	if err := database.SetGenerationModel(r.q.db, r.job.ID, modelURL, ref); err != nil {
+		log.Printf("Failed to store generated model of job %d: %v", r.job.ID, err)
+	}
+}
+
+// convertAll starts a conversion task for every requested format and waits
+// for all of them. The first failure cancels the remaining polls.
+func (r *jobRun) convertAll() error {
+	g, ctx := errgroup.WithContext(r.ctx)
+	for i := range r.job.Conversions {
+		c := &r.job.Conversions[i]
+		if c.ModelURL != "" {
+			continue
+		}
+		g.Go(func() error {
+			return r.convert(ctx, c)
+		})
+	}
+	return g.Wait()
+}
+
+func (r *jobRun) convert(ctx context.Context, c *database.JobConversion) error {
+	if c.TaskID == "" {
+		input := provider.Input{Format: c.Format, Quad: c.Quad, FaceLimit: c.FaceLimit}
+		taskID, err := r.q.provider.Convert(ctx, r.job.GenerateTaskID, input)
+		if err != nil {
+			r.failConversion(c, err)
+			return fmt.Errorf("failed to create %s conversion task: %w", c.Format, err)
+		}
+		r.updateConversion(c, func() {
+			c.TaskID = taskID
+			c.Status = provider.StatusQueued
+		})
+	}
+
+	status, err := provider.Poll(ctx, r.q.provider, c.TaskID, provider.DefaultPollOptions,
+		func(status *provider.TaskStatus) {
+			r.mu.Lock()
+			c.Status = status.Status
+			c.Progress = status.Progress
+			r.saveConversion(c)
+			progress := 0
+			for _, conv := range r.job.Conversions {
+				progress += conv.Progress
+			}
+			r.mu.Unlock()
+
+			r.progress(status, progress/len(r.job.Conversions))
+		})
+	if err != nil {
+		r.failConversion(c, err)
+		return fmt.Errorf("failed to poll %s conversion task: %w", c.Format, err)
+	}
+
+	r.updateConversion(c, func() {
+		c.Status = provider.StatusSuccess
+		c.Progress = 100
+		c.ModelURL = status.ModelURL
+	})
+	return nil
+}
+
+// store saves the first conversion as a new revision, of a new mesh object
+// or of the target mesh, and the others as additional representations of
+// that revision.
+func (r *jobRun) store(files [][]byte) error {
+	job := r.job
+
+	// A resumed job continues the revision its first stored conversion
+	// started.
+	revisionID := 0
+	for _, c := range job.Conversions {
+		if c.RepresentationID != 0 {
+			id, err := r.q.meshes.RevisionOf(c.RepresentationID)
+			if err != nil {
+				return err
+			}
+			revisionID = id
+			break
+		}
+	}
+
+	for i := range job.Conversions {
+		c := &job.Conversions[i]
+		if c.RepresentationID != 0 {
+			continue
+		}
+
+		opts := mesh.Options{Format: c.Format, Quad: c.Quad, FaceLimit: c.FaceLimit, OwnerID: job.UserID}
+		if revisionID == 0 {
+			opts.Origin = r.origin()
+			var stored *mesh.Stored
+			var err error
+			if job.TargetMeshID != 0 {
+				stored, err = r.q.meshes.AddRevision(job.TargetMeshID, files[i], opts)
+			} else {
+				stored, err = r.q.meshes.Create("GeneratedObject", files[i], opts)
+			}
+			if err != nil {
+				return err
+			}
+			revisionID = stored.RevisionID
+			r.update(func(job *database.GenerationJob) bool {
+				job.MeshID = stored.MeshID
+				return false
+			})
+			r.updateConversion(c, func() { c.RepresentationID = stored.RepresentationID })
+			continue
+		}
+
+		representationID, err := r.q.meshes.AddRepresentation(revisionID, files[i], opts)
+		if err != nil {
+			return err
+		}
+		r.updateConversion(c, func() { c.RepresentationID = representationID })
+	}
+	return nil
+}
+
+// origin describes the generation a revision is made from.
+func (r *jobRun) origin() mesh.Origin {
+	job := r.job
+	formats := make([]string, 0, len(job.Conversions))
+	for _, c := range job.Conversions {
+		formats = append(formats, c.Format)
+	}
+	params := map[string]interface{}{
+		"mode":        job.Mode,
+		"conversions": formats,
+	}
+	if job.Filename != "" {
+		params["filename"] = job.Filename
+	}
+	if job.Prompt != "" {
+		params["prompt"] = job.Prompt
+	}
+	if job.NegativePrompt != "" {
+		params["negative_prompt"] = job.NegativePrompt
+	}
+	if job.GenerateTaskID != "" {
+		params["generate_task_id"] = job.GenerateTaskID
+	}
+	return mesh.Origin{Source: mesh.SourceGeneration, JobID: job.ID, Params: params}
+}
+
+// heartbeat renews the lease of the job until the run ends, independently
+// of polls and downloads. When the lease cannot be renewed because the job
+// was cancelled or claimed by another worker, the run is stopped.
+func (r *jobRun) heartbeat() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		renewed, err := database.RenewGenerationJobLease(r.q.db, r.job.ID, r.job.Attempts, lease)
+		if err != nil {
+			log.Printf("Failed to renew lease of job %d: %v", r.job.ID, err)
+			continue
+		}
+		if !renewed {
+			log.Printf("Job %d lost its lease", r.job.ID)
+			r.cancel()
+			return
+		}
+	}
+}
+
+// update applies fn to the job and stores it. When fn reports a status or
+// stage change and the job was stored, the job listeners are notified. It
+// reports whether the job was stored.
+func (r *jobRun) update(fn func(job *database.GenerationJob) bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := fn(r.job)
+	stored := r.save()
+	if stored && changed {
+		r.q.notify(r.job)
+	}
+	return stored
+}
+
+func (r *jobRun) setStage(stage string) {
+	r.update(func(job *database.GenerationJob) bool {
+		if job.Stage == stage {
+			return false
+		}
+		job.Stage = stage
+		job.Progress = 0
+		job.QueuingNum = 0
+		job.RunningLeftTime = 0
+		return true
+	})
+}
+
+// progress records a provider status received while polling. Each store
+// also renews the job lease.
+func (r *jobRun) progress(status *provider.TaskStatus, progress int) {
+	log.Printf("Job %d: task %s status %s", r.job.ID, status.TaskID, status.Status)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.job.Progress = progress
+	r.job.QueuingNum = status.QueuingNum
+	r.job.RunningLeftTime = status.RunningLeftTime
+	r.save()
+	r.q.events.Publish(NewEvent(EventProgress, r.job))
+}
+
+// save stores the job and reports whether it was stored. It cancels the run
+// when the job was cancelled or claimed by another worker. The caller holds
+// r.mu.
+func (r *jobRun) save() bool {
+	stored, err := database.UpdateGenerationJob(r.q.db, r.job, lease)
+	if err != nil {
+		log.Printf("Failed to store job %d: %v", r.job.ID, err)
+		return false
+	}
+	if !stored {
+		log.Printf("Job %d was cancelled or claimed by another worker", r.job.ID)
+		r.cancel()
+	}
+	return stored
+}
+
+func (r *jobRun) updateConversion(c *database.JobConversion, fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fn()
+	r.saveConversion(c)
+}
+
+func (r *jobRun) failConversion(c *database.JobConversion, err error) {
+	r.updateConversion(c, func() {
+		c.Status = provider.StatusFailed
+		var taskErr *provider.TaskError
+		if errors.As(err, &taskErr) {
+			c.Status = taskErr.Status
+		}
+		c.Error = err.Error()
+	})
+}
+
+// saveConversion stores c. The caller holds r.mu.
+func (r *jobRun) saveConversion(c *database.JobConversion) {
+	if err := database.UpdateJobConversion(r.q.db, c, r.job.Attempts); err != nil {
+		log.Printf("Failed to store %s conversion of job %d: %v", c.Format, r.job.ID, err)
+	}
+}
@@ -0,0 +1,132 @@
+package localscript
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// ScriptDir is the checkout of the local neural network. Input files given
+// by name are read from it.
+const ScriptDir = "/home/ubuntu/Neiro"
+
+const (
+	scriptName = "run.py"
+	outputDir  = "output"
+	meshFile   = "0/mesh.usd"
+	photoFile  = "0/example2.jpg"
+)
+
+// killWaitDelay is how long a killed script may keep its output pipes open.
+const killWaitDelay = 10 * time.Second
+
+// ErrInvalidFilename is returned for an input filename that is not the bare
+// name of a file in ScriptDir.
+var ErrInvalidFilename = errors.New("filename must be the name of a file in the script directory")
+
+// Run is one execution of run.py in its own temporary directory, so
+// concurrent runs never see each other's output.
+type Run struct {
+	Dir      string
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// InputPath returns the path of the input file filename in ScriptDir. Paths
+// and names that leave ScriptDir are rejected with ErrInvalidFilename.
+func InputPath(filename string) (string, error) {
+	if filename == "" || filepath.IsAbs(filename) || filepath.Base(filename) != filename {
+		return "", ErrInvalidFilename
+	}
+	path := filepath.Join(ScriptDir, filename)
+	if filepath.Dir(path) != filepath.Clean(ScriptDir) {
+		return "", ErrInvalidFilename
+	}
+	return path, nil
+}
+
+// Start runs the script for the file filename in ScriptDir, see InputPath,
+// or for image when it is set; filename then only names the image. The
+// command is started in the run directory and writes its artifacts there.
+// When ctx ends, the whole process group of the script is killed. The
+// returned Run is never nil, so its output can be reported on error; the
+// caller must call Cleanup once the artifacts have been collected.
+func Start(ctx context.Context, filename string, image []byte) (*Run, error) {
+	if image == nil {
+		path, err := InputPath(filename)
+		if err != nil {
+			return &Run{ExitCode: -1}, err
+		}
+		filename = path
+	}
+
+	dir, err := os.MkdirTemp("", "neiro-run-*")
+	if err != nil {
+		return &Run{ExitCode: -1}, fmt.Errorf("failed to create run directory: %v", err)
+	}
+	run := &Run{Dir: dir, ExitCode: -1}
+
+	if image != nil {
+		name := filepath.Base(filename)
+		if name == "." || name == string(filepath.Separator) {
+			name = "input.jpg"
+		}
+		filename = filepath.Join(dir, name)
+		if err := os.WriteFile(filename, image, 0o644); err != nil {
+			return run, fmt.Errorf("failed to write input image: %v", err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "python", filepath.Join(ScriptDir, scriptName), filename,
+		"--output-dir", filepath.Join(dir, outputDir), "--bake-texture")
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = killWaitDelay
+	setProcessGroup(cmd)
+	log.Printf("Executing command in %s: %s", dir, cmd.String())
+
+	err = cmd.Run()
+	run.Stdout = stdout.Bytes()
+	run.Stderr = stderr.Bytes()
+	run.ExitCode = cmd.ProcessState.ExitCode()
+	if ctx.Err() != nil {
+		return run, fmt.Errorf("script was killed: %w", ctx.Err())
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			run.ExitCode = -1
+		}
+		return run, fmt.Errorf("failed to run script: %v", err)
+	}
+	return run, nil
+}
+
+// MeshPath is the generated mesh.
+func (r *Run) MeshPath() string {
+	return filepath.Join(r.Dir, outputDir, meshFile)
+}
+
+// PhotoPath is the preview image rendered next to the mesh.
+func (r *Run) PhotoPath() string {
+	return filepath.Join(r.Dir, outputDir, photoFile)
+}
+
+// Cleanup removes the run directory with all artifacts.
+func (r *Run) Cleanup() {
+	if r.Dir == "" {
+		return
+	}
+	if err := os.RemoveAll(r.Dir); err != nil {
+		log.Printf("Failed to remove run directory %s: %v", r.Dir, err)
+	}
+}
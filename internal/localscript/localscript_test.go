@@ -0,0 +1,32 @@
+package localscript
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestInputPath(t *testing.T) {
+	path, err := InputPath("chair.jpg")
+	if err != nil {
+		t.Fatalf("InputPath rejected a bare name: %v", err)
+	}
+	if want := filepath.Join(ScriptDir, "chair.jpg"); path != want {
+		t.Errorf("InputPath = %q, want %q", path, want)
+	}
+
+	for _, filename := range []string{
+		"",
+		".",
+		"..",
+		"../chair.jpg",
+		"images/chair.jpg",
+		"images/../chair.jpg",
+		"/etc/passwd",
+		ScriptDir + "/chair.jpg",
+	} {
+		if _, err := InputPath(filename); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("InputPath(%q) = %v, want ErrInvalidFilename", filename, err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package localscript
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go-project/internal/blob"
+	"go-project/internal/database"
+	"go-project/internal/mesh"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// maxStoredOutput is how much of stdout and stderr is kept with a job. Longer
+// output keeps its end, where errors are reported.
+const maxStoredOutput = 256 << 10
+
+var ErrQueueFull = errors.New("script queue is full")
+
+// ErrCancelled is the error of a job stopped by Cancel.
+var ErrCancelled = errors.New("cancelled")
+
+// Pool runs script jobs with a fixed number of workers. Jobs wait in a
+// bounded in-memory queue; when it is full new jobs are rejected instead of
+// starting more scripts than the inference host can hold.
+type Pool struct {
+	db      *pgxpool.Pool
+	workers int
+	timeout time.Duration
+	queue   chan int
+	meshes  *mesh.Service
+	blobs   blob.Store
+
+	mu        sync.Mutex
+	running   map[int]context.CancelFunc
+	cancelled map[int]bool
+
+	onFinish []func(database.ScriptJob)
+}
+
+func NewPool(db *pgxpool.Pool, meshes *mesh.Service, blobs blob.Store, workers int, queueSize int, timeout time.Duration) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	return &Pool{
+		db:        db,
+		workers:   workers,
+		timeout:   timeout,
+		queue:     make(chan int, queueSize),
+		meshes:    meshes,
+		blobs:     blobs,
+		running:   map[int]context.CancelFunc{},
+		cancelled: map[int]bool{},
+	}
+}
+
+// OnFinish registers fn to be called with every job that has finished. It
+// must be called before Start.
+func (p *Pool) OnFinish(fn func(job database.ScriptJob)) {
+	p.onFinish = append(p.onFinish, fn)
+}
+
+func (p *Pool) finished(job database.ScriptJob) {
+	for _, fn := range p.onFinish {
+		fn(job)
+	}
+}
+
+// Start launches the workers. Jobs left unfinished by a previous process
+// cannot be resumed and are marked failed.
+func (p *Pool) Start(ctx context.Context) {
+	failed, err := database.FailUnfinishedScriptJobs(p.db, "interrupted by server restart")
+	if err != nil {
+		log.Printf("Failed to clean up unfinished script jobs: %v", err)
+	} else if len(failed) > 0 {
+		log.Printf("Marked %d unfinished script jobs as failed", len(failed))
+	}
+	for _, job := range failed {
+		p.finished(job)
+	}
+
+	for i := 0; i < p.workers; i++ {
+		go p.work(ctx, i)
+	}
+}
+
+// Submit stores a new job and queues it. Without an image the script reads
+// Filename on the inference host; otherwise it reads the uploaded image,
+// given as Image or, once stored, as ImageRef.
+func (p *Pool) Submit(job database.ScriptJob) (int, error) {
+	if job.Image != nil && job.ImageRef.Key == "" {
+		ref, err := blob.Save(context.Background(), p.blobs, job.Image)
+		if err != nil {
+			return 0, fmt.Errorf("failed to store source image: %v", err)
+		}
+		job.ImageRef = ref
+	}
+	id, err := database.CreateScriptJob(p.db, &job)
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case p.queue <- id:
+		return id, nil
+	default:
+		job.ID = id
+		job.Status = StatusFailed
+		job.Error = ErrQueueFull.Error()
+		if err := database.FinishScriptJob(p.db, &job); err != nil {
+			log.Printf("Failed to store rejected script job %d: %v", id, err)
+		}
+		return 0, ErrQueueFull
+	}
+}
+
+// Cancel stops a job of this process: a running script is killed and a
+// queued job fails without running. Either way the job fails with
+// ErrCancelled.
+func (p *Pool) Cancel(id int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.cancelled[id] = true
+	if cancel, ok := p.running[id]; ok {
+		cancel()
+	}
+}
+
+// track registers the run of a job and reports false when the job has been
+// cancelled already.
+func (p *Pool) track(id int, cancel context.CancelFunc) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancelled[id] {
+		return false
+	}
+	p.running[id] = cancel
+	return true
+}
+
+// untrack forgets the run of a job and reports whether it was cancelled.
+func (p *Pool) untrack(id int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cancelled := p.cancelled[id]
+	delete(p.running, id)
+	delete(p.cancelled, id)
+	return cancelled
+}
+
+// QueueDepth is the number of jobs waiting for a worker.
+func (p *Pool) QueueDepth() int {
+	return len(p.queue)
+}
+
+func (p *Pool) work(ctx context.Context, worker int) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-p.queue:
+			log.Printf("Script worker %d: running job %d", worker, id)
+			p.run(ctx, id)
+		}
+	}
+}
+
+func (p *Pool) run(ctx context.Context, id int) {
+	job, err := database.GetScriptJobByID(p.db, id)
+	if err != nil {
+		log.Printf("Failed to load script job %d: %v", id, err)
+		return
+	}
+	if err := database.StartScriptJob(p.db, id); err != nil {
+		log.Printf("Failed to mark script job %d as running: %v", id, err)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	if !p.track(id, cancel) {
+		p.untrack(id)
+		p.fail(job, ErrCancelled)
+		return
+	}
+
+	if job.ImageRef.Key != "" {
+		job.Image, err = blob.Load(runCtx, p.blobs, job.ImageRef)
+		if err != nil {
+			p.untrack(id)
+			p.fail(job, fmt.Errorf("failed to load source image: %v", err))
+			return
+		}
+	}
+
+	run, err := Start(runCtx, job.Filename, job.Image)
+	defer run.Cleanup()
+	cancelled := p.untrack(id)
+
+	job.Status = StatusSucceeded
+	job.ExitCode = &run.ExitCode
+	job.Stdout = tail(run.Stdout)
+	job.Stderr = tail(run.Stderr)
+	if err == nil {
+		err = p.collect(job, run)
+	} else if cancelled {
+		err = ErrCancelled
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("script timed out after %s", p.timeout)
+	}
+	if err != nil {
+		p.fail(job, err)
+		return
+	}
+	p.finish(job)
+}
+
+func (p *Pool) fail(job *database.ScriptJob, err error) {
+	log.Printf("Script job %d failed: %v", job.ID, err)
+	job.Status = StatusFailed
+	job.Error = err.Error()
+	p.finish(job)
+}
+
+// finish stores the outcome of a job and notifies the OnFinish listeners.
+func (p *Pool) finish(job *database.ScriptJob) {
+	if err := database.FinishScriptJob(p.db, job); err != nil {
+		log.Printf("Failed to store script job %d: %v", job.ID, err)
+	}
+	p.finished(*job)
+}
+
+// collect stores the artifacts of a successful run with the job.
+func (p *Pool) collect(job *database.ScriptJob, run *Run) error {
+	opts := mesh.Options{
+		Format:  "USD",
+		OwnerID: job.OwnerID,
+		Origin: mesh.Origin{
+			Source: mesh.SourceLocalScript,
+			JobID:  job.GenerationJobID,
+			Params: map[string]interface{}{"script_job_id": job.ID, "filename": job.Filename},
+		},
+	}
+	stored, err := p.meshes.CreateFromFile("GeneratedObject", run.MeshPath(), opts)
+	if err != nil {
+		return err
+	}
+	job.MeshID = stored.MeshID
+
+	photo, err := os.ReadFile(run.PhotoPath())
+	if err != nil {
+		return fmt.Errorf("failed to read photo file: %v", err)
+	}
+	job.PhotoRef, err = blob.Save(context.Background(), p.blobs, photo)
+	return err
+}
+
+func tail(output []byte) string {
+	if len(output) > maxStoredOutput {
+		output = output[len(output)-maxStoredOutput:]
+	}
+	return string(output)
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package localscript
+
+import "os/exec"
+
+// setProcessGroup keeps the default cancellation, which kills only the
+// script process itself.
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,128 @@
+package mesh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go-project/internal/blob"
+	"go-project/internal/database"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// purgeBatchSize is how many expired meshes are purged per query.
+const purgeBatchSize = 100
+
+// orphanBlobAge is how old an unreferenced blob must be before it is
+// deleted. Files are written to the store before the row that references
+// them, so younger blobs may belong to a save still in progress.
+const orphanBlobAge = time.Hour
+
+// Collector purges meshes whose restore window has passed and then deletes
+// the blobs no row references any more, including those of deleted items
+// and of saves that failed after storing the file.
+type Collector struct {
+	db       *pgxpool.Pool
+	blobs    blob.Store
+	window   time.Duration
+	interval time.Duration
+}
+
+func NewCollector(db *pgxpool.Pool, blobs blob.Store, window, interval time.Duration) *Collector {
+	return &Collector{db: db, blobs: blobs, window: window, interval: interval}
+}
+
+// Start runs Collect now and then every interval until ctx is done.
+func (c *Collector) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			meshes, blobs, err := c.Collect(ctx)
+			if err != nil {
+				log.Printf("Mesh collector failed: %v", err)
+			}
+			if meshes > 0 || blobs > 0 {
+				log.Printf("Mesh collector purged %d meshes and %d blobs", meshes, blobs)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Collect does one pass and returns how many meshes and blobs it removed.
+func (c *Collector) Collect(ctx context.Context) (int, int, error) {
+	meshes, err := c.purgeExpired(ctx)
+	if err != nil {
+		return meshes, 0, err
+	}
+	blobs, err := c.deleteOrphanBlobs(ctx)
+	return meshes, blobs, err
+}
+
+func (c *Collector) purgeExpired(ctx context.Context) (int, error) {
+	before := time.Now().Add(-c.window)
+	purged := 0
+	for {
+		ids, err := database.ListExpiredMeshObjects(c.db, before, purgeBatchSize)
+		if err != nil {
+			return purged, fmt.Errorf("failed to list expired mesh objects: %v", err)
+		}
+		if len(ids) == 0 {
+			return purged, nil
+		}
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return purged, err
+			}
+			err := database.PurgeMeshObject(c.db, id, before)
+			if errors.Is(err, pgx.ErrNoRows) {
+				// Restored since it was listed.
+				continue
+			}
+			if err != nil {
+				return purged, fmt.Errorf("failed to purge mesh object %d: %v", id, err)
+			}
+			purged++
+		}
+	}
+}
+
+// deleteOrphanBlobs reads the referenced keys before listing the store, so a
+// blob saved during the pass is either referenced or too young to delete.
+func (c *Collector) deleteOrphanBlobs(ctx context.Context) (int, error) {
+	referenced, err := database.ListBlobKeys(c.db)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-orphanBlobAge)
+	var orphans []string
+	err = c.blobs.List(ctx, func(info blob.Info) error {
+		if !referenced[info.Key] && info.ModTime.Before(cutoff) {
+			orphans = append(orphans, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list blobs: %v", err)
+	}
+
+	deleted := 0
+	for _, key := range orphans {
+		if err := c.blobs.Delete(ctx, key); err != nil {
+			return deleted, fmt.Errorf("failed to delete blob %s: %v", key, err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,119 @@
+package mesh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+)
+
+// contentTypes maps mesh formats to the MIME types AR viewers expect.
+var contentTypes = map[string]string{
+	"GLB":  "model/gltf-binary",
+	"GLTF": "model/gltf+json",
+	"USDZ": "model/vnd.usdz+zip",
+	"OBJ":  "model/obj",
+	"STL":  "model/stl",
+	"FBX":  "application/octet-stream",
+	"USD":  "application/octet-stream",
+}
+
+// ContentType returns the MIME type of a mesh format.
+func ContentType(format string) string {
+	if contentType, ok := contentTypes[strings.ToUpper(format)]; ok {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// Filename returns a download name for a mesh with the extension of its
+// format.
+func Filename(name string, format string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\"`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" {
+		name = "mesh"
+	}
+	if format == "" {
+		return name
+	}
+	return name + "." + strings.ToLower(format)
+}
+
+// UploadFormats lists the mesh formats that can be uploaded.
+var UploadFormats = []string{"GLB", "GLTF", "USDZ", "USD", "OBJ", "STL", "FBX"}
+
+// DetectFormat guesses the format of a mesh file from its contents. It
+// returns "" when the contents match none of the known formats.
+func DetectFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("glTF")):
+		return "GLB"
+	case bytes.HasPrefix(data, []byte("Kaydara FBX Binary")):
+		return "FBX"
+	case bytes.HasPrefix(data, []byte("PXR-USDC")), bytes.HasPrefix(data, []byte("#usda")):
+		return "USD"
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		if isUSDZ(data) {
+			return "USDZ"
+		}
+		return ""
+	case isBinarySTL(data):
+		return "STL"
+	}
+
+	text := bytes.TrimSpace(data[:min(len(data), 4096)])
+	switch {
+	case bytes.HasPrefix(text, []byte("solid")) && bytes.Contains(text, []byte("facet")):
+		return "STL"
+	case bytes.HasPrefix(text, []byte("{")) && bytes.Contains(text, []byte(`"asset"`)):
+		return "GLTF"
+	case bytes.HasPrefix(text, []byte("; FBX")):
+		return "FBX"
+	case isOBJ(text):
+		return "OBJ"
+	}
+	return ""
+}
+
+// isUSDZ checks that the first file of a zip archive is a USD layer, which
+// the USDZ format requires.
+func isUSDZ(data []byte) bool {
+	if len(data) < 30 {
+		return false
+	}
+	nameLength := int(binary.LittleEndian.Uint16(data[26:28]))
+	if len(data) < 30+nameLength {
+		return false
+	}
+	name := strings.ToLower(string(data[30 : 30+nameLength]))
+	return strings.HasSuffix(name, ".usd") || strings.HasSuffix(name, ".usdc") || strings.HasSuffix(name, ".usda")
+}
+
+// isBinarySTL checks the triangle count of the header against the file size.
+func isBinarySTL(data []byte) bool {
+	if len(data) < 84 {
+		return false
+	}
+	triangles := int(binary.LittleEndian.Uint32(data[80:84]))
+	return triangles > 0 && len(data) == 84+50*triangles
+}
+
+func isOBJ(text []byte) bool {
+	for _, line := range bytes.Split(text, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		for _, prefix := range []string{"v ", "vt ", "vn ", "f ", "o ", "g ", "mtllib ", "usemtl ", "s "} {
+			if bytes.HasPrefix(line, []byte(prefix)) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package mesh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go-project/internal/database"
+)
+
+// Inspect reads the geometry of a mesh file. format is used when the format
+// cannot be detected from the contents. The geometry is nil for formats
+// that are not parsed (USD, USDZ and FBX) and for files that fail to parse.
+func Inspect(data []byte, format string) (string, *database.MeshGeometry) {
+	detected := DetectFormat(data)
+	if detected != "" {
+		format = detected
+	}
+
+	var geometry *database.MeshGeometry
+	var err error
+	switch strings.ToUpper(format) {
+	case "GLB":
+		geometry, err = inspectGLB(data)
+	case "GLTF":
+		geometry, err = inspectGLTF(data)
+	case "OBJ":
+		geometry, err = inspectOBJ(data)
+	case "STL":
+		geometry, err = inspectSTL(data)
+	}
+	if err != nil {
+		return detected, nil
+	}
+	return detected, geometry
+}
+
+// bounds accumulates the axis-aligned bounding box of vertices.
+type bounds struct {
+	min, max [3]float64
+	empty    bool
+}
+
+func newBounds() *bounds {
+	return &bounds{empty: true}
+}
+
+func (b *bounds) add(p [3]float64) {
+	for i := range p {
+		if b.empty || p[i] < b.min[i] {
+			b.min[i] = p[i]
+		}
+		if b.empty || p[i] > b.max[i] {
+			b.max[i] = p[i]
+		}
+	}
+	b.empty = false
+}
+
+func (b *bounds) geometry(vertices, triangles, materials int) *database.MeshGeometry {
+	return &database.MeshGeometry{
+		Vertices:  vertices,
+		Triangles: triangles,
+		Materials: materials,
+		BBoxMin:   b.min,
+		BBoxMax:   b.max,
+	}
+}
+
+type gltfDocument struct {
+	Accessors []struct {
+		Count int       `json:"count"`
+		Min   []float64 `json:"min"`
+		Max   []float64 `json:"max"`
+	} `json:"accessors"`
+	Meshes []struct {
+		Primitives []struct {
+			Attributes map[string]int `json:"attributes"`
+			Indices    *int           `json:"indices"`
+			Mode       *int           `json:"mode"`
+		} `json:"primitives"`
+	} `json:"meshes"`
+	Materials []json.RawMessage `json:"materials"`
+}
+
+func inspectGLB(data []byte) (*database.MeshGeometry, error) {
+	const jsonChunk = 0x4E4F534A
+	if len(data) < 20 {
+		return nil, errors.New("glb file is too short")
+	}
+	length := int(binary.LittleEndian.Uint32(data[12:16]))
+	if binary.LittleEndian.Uint32(data[16:20]) != jsonChunk || len(data) < 20+length {
+		return nil, errors.New("glb file has no JSON chunk")
+	}
+	return inspectGLTF(data[20 : 20+length])
+}
+
+// inspectGLTF counts the geometry of all meshes of a glTF document. Node
+// transforms are not applied, so the bounding box is the one of the meshes
+// in their own coordinates.
+func inspectGLTF(data []byte) (*database.MeshGeometry, error) {
+	var doc gltfDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	accessor := func(i int) (int, error) {
+		if i < 0 || i >= len(doc.Accessors) {
+			return 0, fmt.Errorf("accessor %d does not exist", i)
+		}
+		return doc.Accessors[i].Count, nil
+	}
+
+	b := newBounds()
+	vertices, triangles := 0, 0
+	seen := map[int]bool{}
+	for _, m := range doc.Meshes {
+		for _, p := range m.Primitives {
+			position, ok := p.Attributes["POSITION"]
+			if !ok {
+				continue
+			}
+			count, err := accessor(position)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[position] {
+				seen[position] = true
+				vertices += count
+				if a := doc.Accessors[position]; len(a.Min) == 3 && len(a.Max) == 3 {
+					b.add([3]float64{a.Min[0], a.Min[1], a.Min[2]})
+					b.add([3]float64{a.Max[0], a.Max[1], a.Max[2]})
+				}
+			}
+
+			if p.Indices != nil {
+				if count, err = accessor(*p.Indices); err != nil {
+					return nil, err
+				}
+			}
+			mode := 4
+			if p.Mode != nil {
+				mode = *p.Mode
+			}
+			switch {
+			case mode == 4:
+				triangles += count / 3
+			case (mode == 5 || mode == 6) && count > 2:
+				triangles += count - 2
+			}
+		}
+	}
+	return b.geometry(vertices, triangles, len(doc.Materials)), nil
+}
+
+func inspectOBJ(data []byte) (*database.MeshGeometry, error) {
+	b := newBounds()
+	vertices, triangles := 0, 0
+	materials := map[string]bool{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "v":
+			p, err := parsePoint(fields[1:])
+			if err != nil {
+				return nil, err
+			}
+			vertices++
+			b.add(p)
+		case "f":
+			if len(fields) > 3 {
+				triangles += len(fields) - 3
+			}
+		case "usemtl":
+			if len(fields) > 1 {
+				materials[fields[1]] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b.geometry(vertices, triangles, len(materials)), nil
+}
+
+// inspectSTL reads binary and ASCII STL. STL has no shared vertices, so
+// every triangle counts three.
+func inspectSTL(data []byte) (*database.MeshGeometry, error) {
+	b := newBounds()
+	if isBinarySTL(data) {
+		triangles := int(binary.LittleEndian.Uint32(data[80:84]))
+		for i := 0; i < triangles; i++ {
+			// Each triangle is a normal and three vertices of three float32.
+			offset := 84 + 50*i + 12
+			for v := 0; v < 3; v++ {
+				var p [3]float64
+				for axis := range p {
+					bits := binary.LittleEndian.Uint32(data[offset+12*v+4*axis:])
+					p[axis] = float64(math.Float32frombits(bits))
+				}
+				b.add(p)
+			}
+		}
+		return b.geometry(3*triangles, triangles, 0), nil
+	}
+
+	vertices, triangles := 0, 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "facet":
+			triangles++
+		case "vertex":
+			p, err := parsePoint(fields[1:])
+			if err != nil {
+				return nil, err
+			}
+			vertices++
+			b.add(p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b.geometry(vertices, triangles, 0), nil
+}
+
+func parsePoint(fields []string) ([3]float64, error) {
+	var p [3]float64
+	if len(fields) < 3 {
+		return p, errors.New("vertex has less than three coordinates")
+	}
+	for i := range p {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return p, err
+		}
+		p[i] = value
+	}
+	return p, nil
+}
@@ -0,0 +1,302 @@
+package mesh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go-project/internal/blob"
+	"go-project/internal/database"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrNotFound               = errors.New("mesh object not found")
+	ErrRevisionNotFound       = errors.New("mesh revision not found")
+	ErrRepresentationNotFound = errors.New("mesh representation not found")
+)
+
+// Revision sources.
+const (
+	SourceGeneration  = "generation"
+	SourceUpload      = "upload"
+	SourceLocalScript = "local_script"
+)
+
+// Options describe how a mesh file was produced. OwnerID is only used when
+// creating a mesh object and Origin only when creating a revision.
+type Options struct {
+	Format    string
+	Quad      bool
+	FaceLimit int
+	OwnerID   int
+	Origin    Origin
+}
+
+// Origin is what a revision was made from. Params is stored with the
+// revision together with the format options.
+type Origin struct {
+	Source string
+	JobID  int
+	Params map[string]interface{}
+}
+
+// Stored identifies a file saved as the first representation of a revision.
+type Stored struct {
+	MeshID           int
+	RevisionID       int
+	Revision         int
+	RepresentationID int
+}
+
+// Mesh is a mesh object with the data of one of its representations and
+// the list of the representations of the same revision.
+type Mesh struct {
+	database.MeshObject
+	Revision        int
+	Representations []database.MeshRepresentation
+}
+
+// Service stores and loads mesh objects. The generation pipelines and the
+// mesh handlers all go through it. Mesh files are kept in the blob store and
+// the database only references them.
+type Service struct {
+	db    *pgxpool.Pool
+	blobs blob.Store
+}
+
+func NewService(db *pgxpool.Pool, blobs blob.Store) *Service {
+	return &Service{db: db, blobs: blobs}
+}
+
+// save stores the file and describes it as a representation.
+func (s *Service) save(data []byte, opts Options) (*database.MeshRepresentation, error) {
+	ref, err := blob.Save(context.Background(), s.blobs, data)
+	if err != nil {
+		return nil, err
+	}
+	detected, geometry := Inspect(data, opts.Format)
+	return &database.MeshRepresentation{
+		Format:         opts.Format,
+		Quad:           opts.Quad,
+		FaceLimit:      opts.FaceLimit,
+		Blob:           ref,
+		DetectedFormat: detected,
+		Geometry:       geometry,
+	}, nil
+}
+
+func (opts Options) revision() *database.MeshRevision {
+	params := map[string]interface{}{
+		"format":     opts.Format,
+		"quad":       opts.Quad,
+		"face_limit": opts.FaceLimit,
+	}
+	for key, value := range opts.Origin.Params {
+		params[key] = value
+	}
+	return &database.MeshRevision{Source: opts.Origin.Source, JobID: opts.Origin.JobID, Params: params}
+}
+
+// Create stores a new mesh object with its first revision. The file is
+// inspected for its real format and geometry.
+func (s *Service) Create(name string, data []byte, opts Options) (*Stored, error) {
+	rep, err := s.save(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	rev := opts.revision()
+	meshID, representationID, err := database.SaveMeshObject(s.db, &database.MeshObject{
+		Name:           name,
+		OwnerID:        opts.OwnerID,
+		Blob:           rep.Blob,
+		Format:         rep.Format,
+		Quad:           rep.Quad,
+		FaceLimit:      rep.FaceLimit,
+		DetectedFormat: rep.DetectedFormat,
+		Geometry:       rep.Geometry,
+	}, rev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save mesh object: %v", err)
+	}
+	return &Stored{MeshID: meshID, RevisionID: rev.ID, Revision: rev.Number, RepresentationID: representationID}, nil
+}
+
+// CreateFromFile is Create for a mesh written to disk by a local script. It
+// reads any path it is given, so it is for pipeline code only and must never
+// receive a path from a client.
+func (s *Service) CreateFromFile(name string, path string, opts Options) (*Stored, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mesh file: %v", err)
+	}
+	return s.Create(name, data, opts)
+}
+
+// AddRevision stores the file as a new revision of an existing mesh object
+// and makes it the current one.
+func (s *Service) AddRevision(meshID int, data []byte, opts Options) (*Stored, error) {
+	rep, err := s.save(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	rev := opts.revision()
+	representationID, err := database.AddMeshRevision(s.db, meshID, rev, rep)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to add revision to mesh object %d: %v", meshID, err)
+	}
+	return &Stored{MeshID: meshID, RevisionID: rev.ID, Revision: rev.Number, RepresentationID: representationID}, nil
+}
+
+// AddRepresentation stores another format of a revision.
+func (s *Service) AddRepresentation(revisionID int, data []byte, opts Options) (int, error) {
+	rep, err := s.save(data, opts)
+	if err != nil {
+		return 0, err
+	}
+	rep.RevisionID = revisionID
+	id, err := database.SaveMeshRepresentation(s.db, rep)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save %s representation: %v", opts.Format, err)
+	}
+	return id, nil
+}
+
+// RevisionOf returns the revision ID of a representation.
+func (s *Service) RevisionOf(representationID int) (int, error) {
+	id, err := database.GetRepresentationRevisionID(s.db, representationID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load revision of representation %d: %v", representationID, err)
+	}
+	return id, nil
+}
+
+// Get loads a mesh object with its file. revision selects an older revision
+// instead of the current one and a non-empty format another representation
+// than the first one of the revision.
+func (s *Service) Get(id int, revision int, format string) (*Mesh, error) {
+	return s.get(id, revision, format, true)
+}
+
+// Describe is Get without reading the file from the blob store.
+func (s *Service) Describe(id int, revision int, format string) (*Mesh, error) {
+	return s.get(id, revision, format, false)
+}
+
+func (s *Service) get(id int, revision int, format string, withData bool) (*Mesh, error) {
+	object, err := database.GetMeshObjectByID(s.db, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mesh object %d: %v", id, err)
+	}
+	m := &Mesh{MeshObject: *object, Revision: object.CurrentRevision}
+	if revision == object.CurrentRevision {
+		revision = 0
+	}
+
+	representationID := 0
+	if format = strings.ToUpper(format); revision != 0 || (format != "" && format != m.Format) {
+		rep, err := database.GetMeshRepresentation(s.db, id, revision, format)
+		if errors.Is(err, pgx.ErrNoRows) {
+			if revision != 0 && format == "" {
+				return nil, ErrRevisionNotFound
+			}
+			return nil, ErrRepresentationNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s representation of mesh object %d: %v", format, id, err)
+		}
+		representationID = rep.ID
+		m.Blob = rep.Blob
+		m.Format = rep.Format
+		m.Quad = rep.Quad
+		m.FaceLimit = rep.FaceLimit
+		m.DetectedFormat = rep.DetectedFormat
+		m.Geometry = rep.Geometry
+		if revision != 0 {
+			m.Revision = revision
+		}
+	}
+
+	if withData {
+		if m.Blob.Key != "" {
+			m.Data, err = blob.Load(context.Background(), s.blobs, m.Blob)
+		} else {
+			m.Data, err = database.GetMeshFileData(s.db, id, representationID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load file of mesh object %d: %v", id, err)
+		}
+	}
+
+	if revision == 0 {
+		m.Representations, err = database.ListMeshRepresentations(s.db, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load representations of mesh object %d: %v", id, err)
+		}
+	}
+	return m, nil
+}
+
+// Revisions lists the revisions of a mesh object, newest first.
+func (s *Service) Revisions(id int) ([]database.MeshRevision, error) {
+	revisions, err := database.ListMeshRevisions(s.db, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load revisions of mesh object %d: %v", id, err)
+	}
+	if len(revisions) == 0 {
+		return nil, ErrNotFound
+	}
+	return revisions, nil
+}
+
+// Restore makes an older revision of a mesh object current again.
+func (s *Service) Restore(id int, revision int) (*database.MeshRevision, error) {
+	rev, err := database.RestoreMeshRevision(s.db, id, revision)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := database.GetMeshObjectByID(s.db, id); errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore revision %d of mesh object %d: %v", revision, id, err)
+	}
+	return rev, nil
+}
+
+// Delete marks a mesh object of ownerID as deleted and returns when. It can
+// be brought back with Undelete until the collector purges it. Meshes of
+// other owners are reported as ErrNotFound.
+func (s *Service) Delete(id int, ownerID int) (time.Time, error) {
+	deletedAt, err := database.SoftDeleteMeshObject(s.db, id, ownerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to delete mesh object %d: %v", id, err)
+	}
+	return deletedAt, nil
+}
+
+// Undelete restores a mesh object of ownerID deleted less than window ago.
+func (s *Service) Undelete(id int, ownerID int, window time.Duration) error {
+	err := database.UndeleteMeshObject(s.db, id, ownerID, time.Now().Add(-window))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore mesh object %d: %v", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestTimeout bounds every request to the provider, including model
+// downloads.
+const requestTimeout = 5 * time.Minute
+
+// APIError is a response of the provider with a non-2xx status or a non-zero
+// code in its JSON body.
+type APIError struct {
+	StatusCode int
+	Code       int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("provider returned status %d, code %d: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("provider returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether repeating the request may succeed. Rate limits,
+// timeouts and server errors are temporary; anything else, such as a
+// rejected key, missing credits or an invalid image, is permanent.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout ||
+		e.StatusCode >= 500
+}
+
+type UploadResponse struct {
+	Code int `json:"code"`
+	Data struct {
+		ImageToken string `json:"image_token"`
+	} `json:"data"`
+}
+
+type TaskResponse struct {
+	Code int `json:"code"`
+	Data struct {
+		TaskID string `json:"task_id"`
+	} `json:"data"`
+}
+
+type FinalResponse struct {
+	Code int `json:"code"`
+	Data struct {
+		TaskID          string `json:"task_id"`
+		Type            string `json:"type"`
+		Status          string `json:"status"`
+		Input           Input  `json:"input"`
+		Output          Output `json:"output"`
+		Progress        int    `json:"progress"`
+		CreateTime      int64  `json:"create_time"`
+		QueuingNum      int    `json:"queuing_num"`
+		RunningLeftTime int    `json:"running_left_time"`
+		Result          Result `json:"result"`
+	} `json:"data"`
+}
+
+type Output struct {
+	Model string `json:"model"`
+}
+
+type Result struct {
+	Model struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	} `json:"model"`
+}
+
+// Cloud talks to the hosted generation API configured by BASE_URL and API_KEY.
+type Cloud struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func NewCloud(baseURL string, apiKey string) *Cloud {
+	return &Cloud{
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: requestTimeout},
+	}
+}
+
+func (c *Cloud) Upload(ctx context.Context, filename string, data []byte) (string, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create form file: %v", err)
+	}
+
+	if _, err = part.Write(data); err != nil {
+		return "", fmt.Errorf("failed to copy file content: %v", err)
+	}
+	writer.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/upload", c.baseURL), body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	var uploadResp UploadResponse
+	if err := c.do(req, &uploadResp); err != nil {
+		return "", fmt.Errorf("upload failed: %w", err)
+	}
+	if uploadResp.Data.ImageToken == "" {
+		return "", fmt.Errorf("upload failed: no image token in response")
+	}
+	return uploadResp.Data.ImageToken, nil
+}
+
+func (c *Cloud) CreateTask(ctx context.Context, task Task) (string, error) {
+	data := map[string]interface{}{"type": task.Type}
+	switch task.Type {
+	case TaskTextToModel:
+		data["prompt"] = task.Prompt
+		if task.NegativePrompt != "" {
+			data["negative_prompt"] = task.NegativePrompt
+		}
+	case TaskMultiviewToModel:
+		files := make([]map[string]string, len(task.Files))
+		for i, file := range task.Files {
+			files[i] = map[string]string{}
+			if file.FileToken != "" {
+				files[i]["type"] = file.FileType
+				files[i]["file_token"] = file.FileToken
+			}
+		}
+		data["files"] = files
+	default:
+		data["file"] = map[string]string{
+			"type":       task.FileType,
+			"file_token": task.FileToken,
+		}
+	}
+	return c.createTask(ctx, data)
+}
+
+func (c *Cloud) Convert(ctx context.Context, originalTaskID string, input Input) (string, error) {
+	data := map[string]interface{}{
+		"type":                   TaskConvertModel,
+		"format":                 input.Format,
+		"original_model_task_id": originalTaskID,
+		"quad":                   input.Quad,
+		"face_limit":             input.FaceLimit,
+	}
+	return c.createTask(ctx, data)
+}
+
+func (c *Cloud) createTask(ctx context.Context, data map[string]interface{}) (string, error) {
+	jsonData, _ := json.Marshal(data)
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/task", c.baseURL), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var taskResp TaskResponse
+	if err := c.do(req, &taskResp); err != nil {
+		return "", fmt.Errorf("failed to create %v task: %w", data["type"], err)
+	}
+	if taskResp.Data.TaskID == "" {
+		return "", fmt.Errorf("failed to create %v task: no task ID in response", data["type"])
+	}
+	return taskResp.Data.TaskID, nil
+}
+
+func (c *Cloud) Poll(ctx context.Context, taskID string) (*TaskStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/task/%s", c.baseURL, taskID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	var finalResp FinalResponse
+	if err := c.do(req, &finalResp); err != nil {
+		return nil, fmt.Errorf("failed to poll task %s: %w", taskID, err)
+	}
+
+	return &TaskStatus{
+		TaskID:          finalResp.Data.TaskID,
+		Status:          finalResp.Data.Status,
+		Progress:        finalResp.Data.Progress,
+		QueuingNum:      finalResp.Data.QueuingNum,
+		RunningLeftTime: finalResp.Data.RunningLeftTime,
+		ModelURL:        finalResp.Data.Result.Model.URL,
+	}, nil
+}
+
+func (c *Cloud) FetchResult(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download file: %w", &APIError{StatusCode: resp.StatusCode, Message: resp.Status})
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the file content: %w", err)
+	}
+	return data, nil
+}
+
+// do sends req and decodes the JSON response into out. A non-2xx status or
+// a non-zero code is returned as an *APIError.
+func (c *Cloud) do(req *http.Request, out interface{}) error {
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	log.Printf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var result struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	parseErr := json.Unmarshal(body, &result)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || (parseErr == nil && result.Code != 0) {
+		message := result.Message
+		if message == "" {
+			message = strings.TrimSpace(string(body))
+			if len(message) > 256 {
+				message = message[:256]
+			}
+		}
+		return &APIError{StatusCode: resp.StatusCode, Code: result.Code, Message: message}
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+	return nil
+}
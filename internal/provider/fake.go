@@ -0,0 +1,126 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// fakePollsToComplete is how many Poll calls a fake task needs before it succeeds.
+const fakePollsToComplete = 3
+
+// Fake is an offline provider. Every task succeeds after a few polls and
+// results are small canned models, so runs are fully deterministic.
+type Fake struct {
+	mu     sync.Mutex
+	lastID int
+	tasks  map[string]*fakeTask
+}
+
+type fakeTask struct {
+	format string
+	polls  int
+}
+
+func NewFake() *Fake {
+	return &Fake{tasks: map[string]*fakeTask{}}
+}
+
+func (f *Fake) Upload(ctx context.Context, filename string, data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", fmt.Errorf("upload failed: empty file %s", filename)
+	}
+	sum := sha256.Sum256(data)
+	return "fake-token-" + hex.EncodeToString(sum[:8]), nil
+}
+
+func (f *Fake) CreateTask(ctx context.Context, task Task) (string, error) {
+	switch task.Type {
+	case TaskImageToModel:
+		if !strings.HasPrefix(task.FileToken, "fake-token-") {
+			return "", fmt.Errorf("failed to create %s task: unknown file token %q", task.Type, task.FileToken)
+		}
+	case TaskMultiviewToModel:
+		if len(task.Files) == 0 || task.Files[0].FileToken == "" {
+			return "", fmt.Errorf("failed to create %s task: front view is required", task.Type)
+		}
+		for _, file := range task.Files {
+			if file.FileToken != "" && !strings.HasPrefix(file.FileToken, "fake-token-") {
+				return "", fmt.Errorf("failed to create %s task: unknown file token %q", task.Type, file.FileToken)
+			}
+		}
+	case TaskTextToModel:
+		if strings.TrimSpace(task.Prompt) == "" {
+			return "", fmt.Errorf("failed to create %s task: empty prompt", task.Type)
+		}
+	default:
+		return "", fmt.Errorf("failed to create %s task: unsupported task type", task.Type)
+	}
+	return f.newTask("GLB"), nil
+}
+
+func (f *Fake) Convert(ctx context.Context, originalTaskID string, input Input) (string, error) {
+	f.mu.Lock()
+	_, ok := f.tasks[originalTaskID]
+	f.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("failed to create %s task: unknown original task %s", TaskConvertModel, originalTaskID)
+	}
+
+	format := strings.ToUpper(input.Format)
+	if _, ok := fakeModels[format]; !ok {
+		return "", fmt.Errorf("failed to create %s task: unsupported format %q", TaskConvertModel, input.Format)
+	}
+	return f.newTask(format), nil
+}
+
+func (f *Fake) Poll(ctx context.Context, taskID string) (*TaskStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	task, ok := f.tasks[taskID]
+	if !ok {
+		return nil, fmt.Errorf("failed to poll task %s: %w", taskID, ErrTaskNotFound)
+	}
+	task.polls++
+
+	status := &TaskStatus{
+		TaskID:          taskID,
+		Status:          StatusRunning,
+		Progress:        100 * task.polls / fakePollsToComplete,
+		RunningLeftTime: fakePollsToComplete - task.polls,
+	}
+	if task.polls >= fakePollsToComplete {
+		status.Status = StatusSuccess
+		status.Progress = 100
+		status.RunningLeftTime = 0
+		status.ModelURL = fmt.Sprintf("fake://%s/model.%s", taskID, strings.ToLower(task.format))
+	}
+	return status, nil
+}
+
+func (f *Fake) FetchResult(ctx context.Context, url string) ([]byte, error) {
+	if !strings.HasPrefix(url, "fake://") {
+		return nil, fmt.Errorf("failed to download file: unexpected url %s", url)
+	}
+
+	ext := url[strings.LastIndex(url, ".")+1:]
+	data, ok := fakeModels[strings.ToUpper(ext)]
+	if !ok {
+		return nil, fmt.Errorf("failed to download file: no canned model for %s", url)
+	}
+	return data, nil
+}
+
+func (f *Fake) newTask(format string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastID++
+	id := fmt.Sprintf("fake-task-%d", f.lastID)
+	f.tasks[id] = &fakeTask{format: format}
+	return id
+}
@@ -0,0 +1,132 @@
+package provider
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// fakeModels holds the canned files returned by the fake provider, keyed by format.
+var fakeModels = map[string][]byte{
+	"GLB":  fakeGLB(),
+	"USDZ": fakeUSDZ(),
+	"FBX":  fakeFBX(),
+	"OBJ":  fakeOBJ(),
+	"STL":  fakeSTL(),
+}
+
+var fakeTriangle = [9]float32{
+	0, 0, 0,
+	1, 0, 0,
+	0, 1, 0,
+}
+
+func fakeGLB() []byte {
+	gltf := []byte(`{"asset":{"version":"2.0","generator":"fake provider"},` +
+		`"scene":0,"scenes":[{"nodes":[0]}],"nodes":[{"mesh":0}],` +
+		`"meshes":[{"primitives":[{"attributes":{"POSITION":0}}]}],` +
+		`"buffers":[{"byteLength":36}],` +
+		`"bufferViews":[{"buffer":0,"byteOffset":0,"byteLength":36}],` +
+		`"accessors":[{"bufferView":0,"componentType":5126,"count":3,"type":"VEC3","min":[0,0,0],"max":[1,1,0]}]}`)
+	for len(gltf)%4 != 0 {
+		gltf = append(gltf, ' ')
+	}
+
+	bin := make([]byte, 0, len(fakeTriangle)*4)
+	for _, v := range fakeTriangle {
+		bin = binary.LittleEndian.AppendUint32(bin, math.Float32bits(v))
+	}
+
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	binary.Write(&buf, le, uint32(0x46546C67)) // "glTF"
+	binary.Write(&buf, le, uint32(2))
+	binary.Write(&buf, le, uint32(12+8+len(gltf)+8+len(bin)))
+	binary.Write(&buf, le, uint32(len(gltf)))
+	binary.Write(&buf, le, uint32(0x4E4F534A)) // "JSON"
+	buf.Write(gltf)
+	binary.Write(&buf, le, uint32(len(bin)))
+	binary.Write(&buf, le, uint32(0x004E4942)) // "BIN\0"
+	buf.Write(bin)
+	return buf.Bytes()
+}
+
+func fakeUSDZ() []byte {
+	usda := []byte(`#usda 1.0
+(
+    defaultPrim = "Model"
+    upAxis = "Y"
+)
+
+def Mesh "Model"
+{
+    int[] faceVertexCounts = [3]
+    int[] faceVertexIndices = [0, 1, 2]
+    point3f[] points = [(0, 0, 0), (1, 0, 0), (0, 1, 0)]
+}
+`)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// USDZ requires file data to start on a 64 byte boundary: the 30 byte
+	// local header plus the name is padded out with an extra field.
+	name := "model.usda"
+	padding := 64 - (30+len(name)+4)%64
+	extra := make([]byte, 4+padding)
+	binary.LittleEndian.PutUint16(extra[0:], 0x1986)
+	binary.LittleEndian.PutUint16(extra[2:], uint16(padding))
+
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Extra: extra})
+	if err != nil {
+		panic(err)
+	}
+	w.Write(usda)
+	if err := zw.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func fakeOBJ() []byte {
+	return []byte(`# fake provider
+o Model
+v 0 0 0
+v 1 0 0
+v 0 1 0
+f 1 2 3
+`)
+}
+
+func fakeSTL() []byte {
+	return []byte(`solid Model
+  facet normal 0 0 1
+    outer loop
+      vertex 0 0 0
+      vertex 1 0 0
+      vertex 0 1 0
+    endloop
+  endfacet
+endsolid Model
+`)
+}
+
+func fakeFBX() []byte {
+	return []byte(`; FBX 7.4.0 project file
+FBXHeaderExtension:  {
+	FBXHeaderVersion: 1003
+	FBXVersion: 7400
+	Creator: "fake provider"
+}
+Objects:  {
+	Geometry: 1, "Geometry::Model", "Mesh" {
+		Vertices: *9 {
+			a: 0,0,0,1,0,0,0,1,0
+		}
+		PolygonVertexIndex: *3 {
+			a: 0,1,-3
+		}
+	}
+}
+`)
+}
@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+var (
+	ErrTaskFailed    = errors.New("task failed")
+	ErrTaskCancelled = errors.New("task cancelled")
+	ErrTaskBanned    = errors.New("task banned")
+	ErrTaskExpired   = errors.New("task expired")
+)
+
+// ErrTaskNotFound is returned by providers polling a task they do not know.
+// Polling it again cannot succeed.
+var ErrTaskNotFound = errors.New("task not found")
+
+// terminalErrors maps final provider statuses other than success to errors.
+var terminalErrors = map[string]error{
+	StatusFailed:    ErrTaskFailed,
+	StatusCancelled: ErrTaskCancelled,
+	StatusBanned:    ErrTaskBanned,
+	StatusExpired:   ErrTaskExpired,
+}
+
+// TaskError is returned by Poll when the provider reports a task as finished
+// without a result. It unwraps to one of the ErrTask* errors.
+type TaskError struct {
+	TaskID string
+	Status string
+	Err    error
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("task %s finished with status %s", e.TaskID, e.Status)
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+type PollOptions struct {
+	// Timeout bounds the whole polling, zero means no deadline besides ctx.
+	Timeout         time.Duration
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+}
+
+var DefaultPollOptions = PollOptions{
+	Timeout:         30 * time.Minute,
+	InitialInterval: 2 * time.Second,
+	MaxInterval:     20 * time.Second,
+}
+
+// Poll queries the task until it succeeds, reaches a terminal status, the
+// timeout passes or ctx is cancelled. The delay between queries doubles up to
+// MaxInterval with random jitter. Failed queries are retried the same way,
+// except for permanent provider errors, which end polling at once. Every
+// received status is passed to report.
+func Poll(ctx context.Context, p Provider, taskID string, opts PollOptions, report func(*TaskStatus)) (*TaskStatus, error) {
+	if taskID == "" {
+		return nil, fmt.Errorf("no task to poll")
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	interval := opts.InitialInterval
+	for {
+		status, err := p.Poll(ctx, taskID)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("polling task %s stopped: %w", taskID, ctx.Err())
+			}
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && !apiErr.Temporary() || errors.Is(err, ErrTaskNotFound) {
+				return nil, err
+			}
+			log.Printf("Failed to poll task %s, retrying: %v", taskID, err)
+		case status.Status == StatusSuccess:
+			report(status)
+			return status, nil
+		case terminalErrors[status.Status] != nil:
+			report(status)
+			return nil, &TaskError{TaskID: taskID, Status: status.Status, Err: terminalErrors[status.Status]}
+		default:
+			report(status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("polling task %s stopped: %w", taskID, ctx.Err())
+		case <-time.After(jitter(interval)):
+		}
+
+		interval *= 2
+		if interval > opts.MaxInterval {
+			interval = opts.MaxInterval
+		}
+	}
+}
+
+// jitter returns a random duration between d/2 and d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,178 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var fastPoll = PollOptions{
+	Timeout:         5 * time.Second,
+	InitialInterval: time.Millisecond,
+	MaxInterval:     2 * time.Millisecond,
+}
+
+// scriptedProvider is a Fake whose Poll first returns errs, one per call.
+// With status set every poll after that reports it instead of the progress
+// of the fake task.
+type scriptedProvider struct {
+	*Fake
+	errs   []error
+	status string
+	polls  int
+}
+
+func (p *scriptedProvider) Poll(ctx context.Context, taskID string) (*TaskStatus, error) {
+	p.polls++
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return nil, err
+	}
+	if p.status != "" {
+		return &TaskStatus{TaskID: taskID, Status: p.status}, nil
+	}
+	return p.Fake.Poll(ctx, taskID)
+}
+
+func TestPoll(t *testing.T) {
+	rejected := &APIError{StatusCode: http.StatusUnauthorized, Message: "invalid api key"}
+
+	for _, tc := range []struct {
+		name      string
+		taskID    string
+		errs      []error
+		status    string
+		wantPolls int
+		wantErr   error
+	}{
+		{
+			name:      "success after polls",
+			wantPolls: fakePollsToComplete,
+		},
+		{
+			name: "transient errors are retried",
+			errs: []error{
+				&APIError{StatusCode: http.StatusServiceUnavailable, Message: "unavailable"},
+				&APIError{StatusCode: http.StatusTooManyRequests, Message: "slow down"},
+				errors.New("connection reset"),
+			},
+			wantPolls: 3 + fakePollsToComplete,
+		},
+		{
+			name:      "permanent api error stops at once",
+			errs:      []error{rejected},
+			wantPolls: 1,
+			wantErr:   rejected,
+		},
+		{
+			name:      "unknown task stops at once",
+			taskID:    "fake-task-missing",
+			wantPolls: 1,
+			wantErr:   ErrTaskNotFound,
+		},
+		{
+			name:      "failed task stops at once",
+			status:    StatusFailed,
+			wantPolls: 1,
+			wantErr:   ErrTaskFailed,
+		},
+		{
+			name:      "banned task stops at once",
+			status:    StatusBanned,
+			wantPolls: 1,
+			wantErr:   ErrTaskBanned,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &scriptedProvider{Fake: NewFake(), errs: tc.errs, status: tc.status}
+			taskID := tc.taskID
+			if taskID == "" {
+				taskID = p.newTask("GLB")
+			}
+
+			reports := 0
+			status, err := Poll(context.Background(), p, taskID, fastPoll, func(*TaskStatus) { reports++ })
+			if p.polls != tc.wantPolls {
+				t.Errorf("polled %d times, want %d", p.polls, tc.wantPolls)
+			}
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("Poll returned %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Poll returned %v", err)
+			}
+			if status.Status != StatusSuccess || status.ModelURL == "" {
+				t.Errorf("Poll returned status %+v", status)
+			}
+			if reports != fakePollsToComplete {
+				t.Errorf("reported %d statuses, want %d", reports, fakePollsToComplete)
+			}
+		})
+	}
+}
+
+func TestPollReturnsTaskError(t *testing.T) {
+	p := &scriptedProvider{Fake: NewFake(), status: StatusExpired}
+	_, err := Poll(context.Background(), p, p.newTask("GLB"), fastPoll, func(*TaskStatus) {})
+
+	var taskErr *TaskError
+	if !errors.As(err, &taskErr) {
+		t.Fatalf("Poll returned %v, want a *TaskError", err)
+	}
+	if taskErr.Status != StatusExpired || !errors.Is(err, ErrTaskExpired) {
+		t.Errorf("Poll returned %v with status %s", err, taskErr.Status)
+	}
+}
+
+func TestPollTimesOut(t *testing.T) {
+	p := &scriptedProvider{Fake: NewFake(), status: StatusRunning}
+	opts := fastPoll
+	opts.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	_, err := Poll(context.Background(), p, p.newTask("GLB"), opts, func(*TaskStatus) {})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Poll returned %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Poll took %s to time out", elapsed)
+	}
+	if p.polls < 2 {
+		t.Errorf("polled %d times before the timeout", p.polls)
+	}
+}
+
+func TestPollStopsOnCancel(t *testing.T) {
+	p := &scriptedProvider{Fake: NewFake(), status: StatusRunning}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	_, err := Poll(ctx, p, p.newTask("GLB"), fastPoll, func(*TaskStatus) {})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Poll returned %v, want context.Canceled", err)
+	}
+}
+
+func TestAPIErrorTemporary(t *testing.T) {
+	for statusCode, want := range map[int]bool{
+		http.StatusTooManyRequests:     true,
+		http.StatusRequestTimeout:      true,
+		http.StatusInternalServerError: true,
+		http.StatusBadGateway:          true,
+		http.StatusBadRequest:          false,
+		http.StatusUnauthorized:        false,
+		http.StatusPaymentRequired:     false,
+		http.StatusNotFound:            false,
+	} {
+		err := &APIError{StatusCode: statusCode}
+		if got := err.Temporary(); got != want {
+			t.Errorf("Temporary() of status %d = %v, want %v", statusCode, got, want)
+		}
+	}
+}
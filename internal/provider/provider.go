@@ -0,0 +1,121 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	TaskImageToModel     = "image_to_model"
+	TaskTextToModel      = "text_to_model"
+	TaskMultiviewToModel = "multiview_to_model"
+	TaskConvertModel     = "convert_model"
+)
+
+// Views lists the views of a multiview task in the order the provider
+// expects them. The front view is required.
+var Views = []string{"front", "left", "back", "right"}
+
+const (
+	MaxPromptLength         = 1024
+	MaxNegativePromptLength = 255
+)
+
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSuccess   = "success"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+	StatusBanned    = "banned"
+	StatusExpired   = "expired"
+)
+
+// Provider is an image-to-3D generation backend. Tasks are started with
+// CreateTask or Convert and observed with Poll until they reach a final status.
+type Provider interface {
+	Upload(ctx context.Context, filename string, data []byte) (string, error)
+	CreateTask(ctx context.Context, task Task) (string, error)
+	Convert(ctx context.Context, originalTaskID string, input Input) (string, error)
+	Poll(ctx context.Context, taskID string) (*TaskStatus, error)
+	FetchResult(ctx context.Context, url string) ([]byte, error)
+}
+
+// Task is a generation task. Image tasks use FileType and FileToken, text
+// tasks use Prompt and NegativePrompt and multiview tasks use Files.
+type Task struct {
+	Type           string
+	FileType       string
+	FileToken      string
+	Prompt         string
+	NegativePrompt string
+	Files          []TaskFile
+}
+
+// TaskFile is one image of a multiview task. Files are ordered like Views;
+// a view without an image has an empty FileToken.
+type TaskFile struct {
+	FileType  string
+	FileToken string
+}
+
+type Input struct {
+	OriginalModelID string `json:"original_model_id"`
+	Format          string `json:"format"`
+	Quad            bool   `json:"quad"`
+	FaceLimit       int    `json:"face_limit"`
+}
+
+// MaxFaceLimit is the largest face_limit accepted for a conversion.
+const MaxFaceLimit = 500000
+
+// ConversionFormats lists the output formats a model can be converted to.
+var ConversionFormats = []string{"GLB", "USDZ", "FBX", "OBJ", "STL"}
+
+// DefaultConversion is used when a request does not specify conversion options.
+var DefaultConversion = Input{Format: "USDZ", Quad: true, FaceLimit: 5000}
+
+// Validate normalizes the format name and checks the conversion options.
+func (in *Input) Validate() error {
+	in.Format = strings.ToUpper(strings.TrimSpace(in.Format))
+
+	supported := false
+	for _, format := range ConversionFormats {
+		if in.Format == format {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return fmt.Errorf("unsupported format %q, expected one of %s", in.Format, strings.Join(ConversionFormats, ", "))
+	}
+
+	if in.FaceLimit <= 0 || in.FaceLimit > MaxFaceLimit {
+		return fmt.Errorf("face_limit must be between 1 and %d", MaxFaceLimit)
+	}
+	return nil
+}
+
+type TaskStatus struct {
+	TaskID          string
+	Status          string
+	Progress        int
+	QueuingNum      int
+	RunningLeftTime int
+	ModelURL        string
+}
+
+func New(name string, baseURL string, apiKey string) (Provider, error) {
+	switch name {
+	case "", "cloud":
+		if baseURL == "" || apiKey == "" {
+			return nil, fmt.Errorf("BASE_URL and API_KEY are required for the cloud provider")
+		}
+		return NewCloud(baseURL, apiKey), nil
+	case "fake":
+		return NewFake(), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+}
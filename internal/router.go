@@ -11,12 +11,33 @@ func SetupRouter() (*mux.Router) {
 
     //1 нейронка
 	router.HandleFunc("/api/run-script", api.RunScript).Methods("POST")
+	router.HandleFunc("/api/script-jobs/{id:[0-9]+}", api.GetScriptJobHandler).Methods("GET")
+
+	router.HandleFunc("/api/generate", api.GenerateHandler).Methods("POST")
 
     //2 нейронка
     router.HandleFunc("/api/newrun-script", api.ProcessAll).Methods("POST")
+	router.HandleFunc("/api/jobs/{id:[0-9]+}", api.GetJobHandler).Methods("GET")
+	router.HandleFunc("/api/jobs/{id:[0-9]+}/cancel", api.CancelJobHandler).Methods("POST")
+	router.HandleFunc("/api/jobs/{id:[0-9]+}/events", api.JobEventsHandler).Methods("GET")
+	router.HandleFunc("/api/jobs/{id:[0-9]+}/ws", api.JobWebSocketHandler).Methods("GET")
+	router.HandleFunc("/api/jobs/{id:[0-9]+}/webhook-deliveries", api.GetWebhookDeliveriesHandler).Methods("GET")
+	router.HandleFunc("/api/webhooks", api.RegisterWebhookHandler).Methods("POST")
+	router.HandleFunc("/api/generations/{id:[0-9]+}", api.GetGenerationHandler).Methods("GET")
+	router.HandleFunc("/api/generations/{id:[0-9]+}/model", api.GetGenerationModelHandler).Methods("GET")
+	router.HandleFunc("/api/generations/{id:[0-9]+}/source-image", api.GetGenerationSourceImageHandler).Methods("GET")
+	router.HandleFunc("/api/users/{id:[0-9]+}/credits", api.GetCreditsHandler).Methods("GET")
+	router.HandleFunc("/api/users/{id:[0-9]+}/credits", api.RequireAdmin(api.GrantCreditsHandler)).Methods("POST")
 
     router.HandleFunc("/api/mesh", api.SaveMeshObjectHandler).Methods("POST")
+	router.HandleFunc("/api/mesh", api.ListMeshObjectsHandler).Methods("GET")
 	router.HandleFunc("/api/mesh/{id:[0-9]+}", api.GetMeshObjectHandler).Methods("GET")
+	router.HandleFunc("/api/mesh/{id:[0-9]+}", api.DeleteMeshObjectHandler).Methods("DELETE")
+	router.HandleFunc("/api/mesh/{id:[0-9]+}/restore", api.UndeleteMeshObjectHandler).Methods("POST")
+	router.HandleFunc("/api/mesh/{id:[0-9]+}/file", api.GetMeshFileHandler).Methods("GET", "HEAD")
+	router.HandleFunc("/api/mesh/{id:[0-9]+}/revisions", api.GetMeshRevisionsHandler).Methods("GET")
+	router.HandleFunc("/api/mesh/{id:[0-9]+}/revisions", api.SaveMeshRevisionHandler).Methods("POST")
+	router.HandleFunc("/api/mesh/{id:[0-9]+}/revisions/{revision:[0-9]+}/restore", api.RestoreMeshRevisionHandler).Methods("POST")
 	router.HandleFunc("/api/upload", api.UploadImage).Methods("POST")
 
 	router.HandleFunc("/api/register", api.RegisterHandler).Methods("POST")
@@ -0,0 +1,57 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// AdminTokenHeader carries the ADMIN_TOKEN secret on admin-only routes.
+const AdminTokenHeader = "X-Admin-Token"
+
+// NewToken returns a random session token. Only its Hash is stored.
+func NewToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// Hash returns the hex SHA-256 of a token, under which its session is stored.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header.
+func BearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	if !ok || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// IsAdmin reports whether the request carries adminToken in the
+// X-Admin-Token header. It is always false when adminToken is empty.
+func IsAdmin(r *http.Request, adminToken string) bool {
+	token := r.Header.Get(AdminTokenHeader)
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// RequireAdmin lets a request through to next only when IsAdmin. Without
+// adminToken the route is disabled.
+func RequireAdmin(adminToken string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !IsAdmin(r, adminToken) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,106 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	token := "0123456789abcdef"
+	sum := sha256.Sum256([]byte(token))
+
+	got := Hash(token)
+	if got != hex.EncodeToString(sum[:]) {
+		t.Errorf("Hash(%q) = %s", token, got)
+	}
+	if Hash(token) != got {
+		t.Error("Hash is not deterministic")
+	}
+	if Hash(token+"0") == got {
+		t.Error("different tokens have the same hash")
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	first, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 64 {
+		t.Errorf("token %q is not 32 hex encoded bytes", first)
+	}
+	if _, err := hex.DecodeString(first); err != nil {
+		t.Errorf("token %q is not hex: %v", first, err)
+	}
+	if first == second {
+		t.Error("two tokens are equal")
+	}
+	if Hash(first) == first {
+		t.Error("the hash of a token is the token")
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                   "",
+		"Bearer abc":         "abc",
+		"Bearer  abc ":       "abc",
+		"Bearer ":            "",
+		"bearer abc":         "",
+		"Basic dXNlcjpwdw==": "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		got, ok := BearerToken(r)
+		if got != want || ok != (want != "") {
+			t.Errorf("BearerToken(%q) = %q, %v, want %q", header, got, ok, want)
+		}
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		adminToken string
+		header     string
+		want       int
+	}{
+		{name: "disabled without admin token", want: http.StatusForbidden},
+		{name: "disabled even for a matching empty header", header: " ", want: http.StatusForbidden},
+		{name: "missing header", adminToken: "secret", want: http.StatusForbidden},
+		{name: "wrong token", adminToken: "secret", header: "secreT", want: http.StatusForbidden},
+		{name: "prefix of the token", adminToken: "secret", header: "secre", want: http.StatusForbidden},
+		{name: "right token", adminToken: "secret", header: "secret", want: http.StatusNoContent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+			handler := RequireAdmin(tc.adminToken, func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "/api/users/1/credits", nil)
+			if tc.header != "" {
+				r.Header.Set(AdminTokenHeader, tc.header)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tc.want {
+				t.Errorf("status %d, want %d", w.Code, tc.want)
+			}
+			if called != (tc.want == http.StatusNoContent) {
+				t.Errorf("next handler called: %v", called)
+			}
+		})
+	}
+}
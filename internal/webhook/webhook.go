@@ -0,0 +1,332 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"go-project/internal/database"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+)
+
+const (
+	maxAttempts       = 5
+	initialRetryDelay = 2 * time.Second
+	requestTimeout    = 10 * time.Second
+	deliveryWorkers   = 4
+	idlePollInterval  = 5 * time.Second
+)
+
+// deliveryLease is how long a claimed message stays locked. Messages of a
+// crashed process are sent again once it runs out.
+const deliveryLease = 2 * requestTimeout
+
+type Payload struct {
+	Event      string    `json:"event"`
+	JobID      int       `json:"job_id"`
+	Status     string    `json:"status"`
+	Stage      string    `json:"stage"`
+	Backend    string    `json:"backend,omitempty"`
+	MeshID     int       `json:"mesh_id,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// Sign returns the signature header value for body: the hex HMAC-SHA256 of
+// the body keyed with secret, prefixed with "sha256=".
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature header value produced by Sign.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// ErrPrivateAddress is returned for callbacks to loopback, link-local or
+// private addresses, which would let clients reach internal services.
+var ErrPrivateAddress = errors.New("callback address is not public")
+
+// ValidateURL accepts absolute http and https URLs whose host is not a
+// loopback, link-local or private address. Host names are checked again
+// when a delivery connects, see newClient.
+func ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback url must be an absolute http or https url")
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateAddress
+	}
+	if ip := net.ParseIP(host); ip != nil && !publicIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() &&
+		!ip.IsUnspecified()
+}
+
+// newClient returns an HTTP client that refuses to connect to non-public
+// addresses. The check runs on the resolved address of every connection, so
+// it also covers DNS names and redirects. Proxies are not used, as they
+// would connect on the client's behalf.
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: requestTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: requestTimeout, Transport: transport}
+}
+
+// Outbox keeps the webhooks waiting to be sent. It is backed by the
+// webhook_outbox table; see the database package for the ordering rules.
+type Outbox interface {
+	// Add queues messages in the given order.
+	Add(messages []database.WebhookMessage) error
+	// Claim locks the next message to send for lease, or returns nil.
+	Claim(lease time.Duration) (*database.WebhookMessage, error)
+	// Finish logs the last attempt of a message, delivered or given up.
+	Finish(m *database.WebhookMessage, delivery *database.WebhookDelivery) error
+	// Retry logs a failed attempt and schedules the next one at at.
+	Retry(m *database.WebhookMessage, delivery *database.WebhookDelivery, at time.Time) error
+}
+
+type dbOutbox struct {
+	db *pgxpool.Pool
+}
+
+func (o dbOutbox) Add(messages []database.WebhookMessage) error {
+	return database.CreateWebhookMessages(o.db, messages)
+}
+
+func (o dbOutbox) Claim(lease time.Duration) (*database.WebhookMessage, error) {
+	return database.ClaimWebhookMessage(o.db, lease)
+}
+
+func (o dbOutbox) Finish(m *database.WebhookMessage, delivery *database.WebhookDelivery) error {
+	return database.FinishWebhookMessage(o.db, m, delivery)
+}
+
+func (o dbOutbox) Retry(m *database.WebhookMessage, delivery *database.WebhookDelivery, at time.Time) error {
+	return database.RetryWebhookMessage(o.db, m, delivery, at)
+}
+
+// Dispatcher posts job state changes to the callback URL of the job and to
+// the URL registered by the job's API client. Changes are queued in the
+// outbox and sent by background workers, in order for each job and URL.
+// Failed deliveries are retried with a growing delay, also after a restart,
+// and every attempt is written to webhook_deliveries.
+type Dispatcher struct {
+	db         *pgxpool.Pool
+	outbox     Outbox
+	client     *http.Client
+	retryDelay time.Duration
+	idlePoll   time.Duration
+	wake       chan struct{}
+}
+
+func NewDispatcher(db *pgxpool.Pool) *Dispatcher {
+	return newDispatcher(db, dbOutbox{db: db}, newClient())
+}
+
+func newDispatcher(db *pgxpool.Pool, outbox Outbox, client *http.Client) *Dispatcher {
+	return &Dispatcher{
+		db:         db,
+		outbox:     outbox,
+		client:     client,
+		retryDelay: initialRetryDelay,
+		idlePoll:   idlePollInterval,
+		wake:       make(chan struct{}, deliveryWorkers),
+	}
+}
+
+// Start launches the delivery workers.
+func (d *Dispatcher) Start(ctx context.Context) {
+	for i := 0; i < deliveryWorkers; i++ {
+		go d.work(ctx)
+	}
+}
+
+// JobChanged queues the current state of job for delivery.
+func (d *Dispatcher) JobChanged(job database.GenerationJob) {
+	payload := Payload{
+		Event:      "job." + job.Status,
+		JobID:      job.ID,
+		Status:     job.Status,
+		Stage:      job.Stage,
+		Backend:    job.Backend,
+		MeshID:     job.MeshID,
+		Error:      job.Error,
+		OccurredAt: time.Now().UTC(),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode webhook payload for job %d: %v", job.ID, err)
+		return
+	}
+
+	var messages []database.WebhookMessage
+	message := func(url string, secret string) database.WebhookMessage {
+		return database.WebhookMessage{JobID: job.ID, URL: url, Secret: secret, Event: payload.Event, Payload: string(body)}
+	}
+	if job.CallbackURL != "" {
+		messages = append(messages, message(job.CallbackURL, job.CallbackSecret))
+	}
+	if job.ClientID != "" {
+		sub, err := database.GetWebhookSubscriptionByClientID(d.db, job.ClientID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Failed to load webhook subscription of client %s: %v", job.ClientID, err)
+		}
+		if err == nil {
+			messages = append(messages, message(sub.URL, sub.Secret))
+		}
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	if err := d.outbox.Add(messages); err != nil {
+		log.Printf("Failed to queue webhook %s for job %d: %v", payload.Event, job.ID, err)
+		return
+	}
+	for range messages {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		m, err := d.outbox.Claim(deliveryLease)
+		if err != nil {
+			log.Printf("Failed to claim webhook: %v", err)
+		}
+		if m != nil {
+			d.deliver(m)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-time.After(d.idlePoll):
+		}
+	}
+}
+
+// deliver sends one attempt of m and records the outcome.
+func (d *Dispatcher) deliver(m *database.WebhookMessage) {
+	body := []byte(m.Payload)
+	delivery := database.WebhookDelivery{
+		JobID:   m.JobID,
+		URL:     m.URL,
+		Event:   m.Event,
+		Payload: m.Payload,
+		Attempt: m.Attempts,
+	}
+
+	statusCode, err := d.post(m.URL, m.Secret, m.Event, body)
+	delivery.StatusCode = statusCode
+	if err != nil {
+		delivery.Error = err.Error()
+	} else {
+		delivery.Delivered = true
+	}
+
+	if delivery.Delivered || m.Attempts >= maxAttempts {
+		if !delivery.Delivered {
+			log.Printf("Webhook %s for job %d to %s failed, giving up after %d attempts: %v",
+				m.Event, m.JobID, m.URL, m.Attempts, err)
+		}
+		if err := d.outbox.Finish(m, &delivery); err != nil {
+			log.Printf("Failed to record webhook delivery for job %d: %v", m.JobID, err)
+		}
+		// The next message of the job may be waiting for this one.
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+		return
+	}
+
+	log.Printf("Webhook %s for job %d to %s failed (attempt %d/%d): %v",
+		m.Event, m.JobID, m.URL, m.Attempts, maxAttempts, err)
+	retryAt := time.Now().Add(d.retryDelay << (m.Attempts - 1))
+	if err := d.outbox.Retry(m, &delivery, retryAt); err != nil {
+		log.Printf("Failed to record webhook delivery for job %d: %v", m.JobID, err)
+	}
+}
+
+func (d *Dispatcher) post(target string, secret string, event string, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(SignatureHeader, Sign(secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,260 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-project/internal/database"
+)
+
+// memOutbox is an Outbox in memory with the ordering rules of the
+// webhook_outbox table.
+type memOutbox struct {
+	mu         sync.Mutex
+	messages   []*database.WebhookMessage
+	locked     map[int64]time.Time
+	deliveries []database.WebhookDelivery
+}
+
+func (o *memOutbox) Add(messages []database.WebhookMessage) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, m := range messages {
+		m.ID = int64(len(o.messages) + 1)
+		m.Status = database.WebhookPending
+		m.NextAttemptAt = time.Now()
+		o.messages = append(o.messages, &m)
+	}
+	return nil
+}
+
+func (o *memOutbox) Claim(lease time.Duration) (*database.WebhookMessage, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	blocked := map[[2]interface{}]bool{}
+	for _, m := range o.messages {
+		if m.Status != database.WebhookPending {
+			continue
+		}
+		key := [2]interface{}{m.JobID, m.URL}
+		if blocked[key] {
+			continue
+		}
+		blocked[key] = true
+		if m.NextAttemptAt.After(now) || o.locked[m.ID].After(now) {
+			continue
+		}
+
+		if o.locked == nil {
+			o.locked = map[int64]time.Time{}
+		}
+		o.locked[m.ID] = now.Add(lease)
+		m.Attempts++
+		claimed := *m
+		return &claimed, nil
+	}
+	return nil, nil
+}
+
+func (o *memOutbox) Finish(m *database.WebhookMessage, delivery *database.WebhookDelivery) error {
+	status := database.WebhookFailed
+	if delivery.Delivered {
+		status = database.WebhookDelivered
+	}
+	return o.update(m, delivery, func(stored *database.WebhookMessage) { stored.Status = status })
+}
+
+func (o *memOutbox) Retry(m *database.WebhookMessage, delivery *database.WebhookDelivery, at time.Time) error {
+	return o.update(m, delivery, func(stored *database.WebhookMessage) { stored.NextAttemptAt = at })
+}
+
+func (o *memOutbox) update(m *database.WebhookMessage, delivery *database.WebhookDelivery, fn func(*database.WebhookMessage)) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	fn(o.messages[m.ID-1])
+	delete(o.locked, m.ID)
+	o.deliveries = append(o.deliveries, *delivery)
+	return nil
+}
+
+func (o *memOutbox) attempts(event string) []database.WebhookDelivery {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var attempts []database.WebhookDelivery
+	for _, d := range o.deliveries {
+		if d.Event == event {
+			attempts = append(attempts, d)
+		}
+	}
+	return attempts
+}
+
+// receiver records the events it accepts. It answers the first failures
+// requests for failEvent with a server error.
+type receiver struct {
+	t         *testing.T
+	secret    string
+	failEvent string
+	failures  int
+
+	mu       sync.Mutex
+	received []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Errorf("failed to read body: %v", err)
+		return
+	}
+	if !Verify(rc.secret, body, r.Header.Get(SignatureHeader)) {
+		rc.t.Errorf("invalid signature %q", r.Header.Get(SignatureHeader))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		rc.t.Errorf("invalid payload %s: %v", body, err)
+		return
+	}
+	if payload.Event != r.Header.Get(EventHeader) {
+		rc.t.Errorf("event header %q does not match payload event %q", r.Header.Get(EventHeader), payload.Event)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if payload.Event == rc.failEvent && rc.failures > 0 {
+		rc.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	rc.received = append(rc.received, payload.Event)
+}
+
+func (rc *receiver) wait(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rc.mu.Lock()
+		received := append([]string(nil), rc.received...)
+		rc.mu.Unlock()
+		if len(received) >= n {
+			return received
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("received %v, want %d events", received, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func startDispatcher(t *testing.T, server *httptest.Server) (*Dispatcher, *memOutbox) {
+	outbox := &memOutbox{}
+	d := newDispatcher(nil, outbox, server.Client())
+	d.retryDelay = 10 * time.Millisecond
+	d.idlePoll = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	d.Start(ctx)
+	return d, outbox
+}
+
+func TestDispatcherRetriesAndKeepsOrder(t *testing.T) {
+	const secret = "test-secret"
+	rc := &receiver{t: t, secret: secret, failEvent: "job.running", failures: 2}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	d, outbox := startDispatcher(t, server)
+
+	for _, status := range []string{"queued", "running", "succeeded"} {
+		d.JobChanged(database.GenerationJob{ID: 1, Status: status, CallbackURL: server.URL, CallbackSecret: secret})
+	}
+
+	received := rc.wait(t, 3)
+	want := []string{"job.queued", "job.running", "job.succeeded"}
+	if len(received) != len(want) {
+		t.Fatalf("received %v, want %v", received, want)
+	}
+	for i := range want {
+		if received[i] != want[i] {
+			t.Fatalf("received %v, want %v", received, want)
+		}
+	}
+
+	attempts := outbox.attempts("job.running")
+	if len(attempts) != 3 {
+		t.Fatalf("job.running took %d attempts, want 3", len(attempts))
+	}
+	for i, a := range attempts {
+		if a.Attempt != i+1 {
+			t.Errorf("attempt %d recorded as %d", i+1, a.Attempt)
+		}
+		if delivered := i == 2; a.Delivered != delivered {
+			t.Errorf("attempt %d delivered = %v, want %v", i+1, a.Delivered, delivered)
+		}
+	}
+	if attempts[0].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("failed attempt recorded status %d", attempts[0].StatusCode)
+	}
+}
+
+func TestDispatcherGivesUpAndSendsLaterEvents(t *testing.T) {
+	const secret = "test-secret"
+	rc := &receiver{t: t, secret: secret, failEvent: "job.running", failures: maxAttempts}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	d, outbox := startDispatcher(t, server)
+
+	for _, status := range []string{"running", "failed"} {
+		d.JobChanged(database.GenerationJob{ID: 2, Status: status, CallbackURL: server.URL, CallbackSecret: secret})
+	}
+
+	received := rc.wait(t, 1)
+	if received[0] != "job.failed" {
+		t.Fatalf("received %v, want [job.failed]", received)
+	}
+	if attempts := outbox.attempts("job.running"); len(attempts) != maxAttempts {
+		t.Fatalf("job.running took %d attempts, want %d", len(attempts), maxAttempts)
+	}
+}
+
+func TestValidateURLRejectsPrivateAddresses(t *testing.T) {
+	for _, rawURL := range []string{
+		"http://localhost/hook",
+		"http://127.0.0.1:8080/hook",
+		"http://[::1]/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+	} {
+		if err := ValidateURL(rawURL); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("ValidateURL(%q) = %v, want ErrPrivateAddress", rawURL, err)
+		}
+	}
+	if err := ValidateURL("https://example.com/hook"); err != nil {
+		t.Errorf("ValidateURL rejected a public url: %v", err)
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := newClient().Get(server.URL)
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("request to %s returned %v, want ErrPrivateAddress", server.URL, err)
+	}
+}
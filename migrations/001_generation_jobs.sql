@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS generation_jobs (
+    id                SERIAL PRIMARY KEY,
+    status            TEXT        NOT NULL DEFAULT 'queued',
+    stage             TEXT        NOT NULL DEFAULT 'upload',
+    filename          TEXT        NOT NULL,
+    source_image      BYTEA       NOT NULL,
+    image_token       TEXT        NOT NULL DEFAULT '',
+    generate_task_id  TEXT        NOT NULL DEFAULT '',
+    convert_task_id   TEXT        NOT NULL DEFAULT '',
+    model_url         TEXT        NOT NULL DEFAULT '',
+    progress          INT         NOT NULL DEFAULT 0,
+    queuing_num       INT         NOT NULL DEFAULT 0,
+    running_left_time INT         NOT NULL DEFAULT 0,
+    mesh_id           INT         REFERENCES mesh_objects (id),
+    error             TEXT        NOT NULL DEFAULT '',
+    attempts          INT         NOT NULL DEFAULT 0,
+    locked_until      TIMESTAMPTZ,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS generation_jobs_claim_idx ON generation_jobs (status, id);
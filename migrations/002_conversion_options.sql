@@ -0,0 +1,9 @@
+ALTER TABLE generation_jobs
+    ADD COLUMN IF NOT EXISTS format     TEXT    NOT NULL DEFAULT 'USDZ',
+    ADD COLUMN IF NOT EXISTS quad       BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN IF NOT EXISTS face_limit INT     NOT NULL DEFAULT 5000;
+
+ALTER TABLE mesh_objects
+    ADD COLUMN IF NOT EXISTS format     TEXT    NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS quad       BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS face_limit INT     NOT NULL DEFAULT 0;
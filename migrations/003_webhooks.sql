@@ -0,0 +1,27 @@
+ALTER TABLE generation_jobs
+    ADD COLUMN IF NOT EXISTS client_id       TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS callback_url    TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS callback_secret TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id         SERIAL PRIMARY KEY,
+    client_id  TEXT        NOT NULL UNIQUE,
+    url        TEXT        NOT NULL,
+    secret     TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id          SERIAL PRIMARY KEY,
+    job_id      INT         NOT NULL REFERENCES generation_jobs (id),
+    url         TEXT        NOT NULL,
+    event       TEXT        NOT NULL,
+    payload     TEXT        NOT NULL,
+    attempt     INT         NOT NULL,
+    status_code INT         NOT NULL DEFAULT 0,
+    error       TEXT        NOT NULL DEFAULT '',
+    delivered   BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_job_idx ON webhook_deliveries (job_id, id);
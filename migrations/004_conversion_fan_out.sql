@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS mesh_representations (
+    id         SERIAL PRIMARY KEY,
+    mesh_id    INT         NOT NULL REFERENCES mesh_objects (id),
+    format     TEXT        NOT NULL,
+    quad       BOOLEAN     NOT NULL DEFAULT FALSE,
+    face_limit INT         NOT NULL DEFAULT 0,
+    data       BYTEA       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS mesh_representations_mesh_idx ON mesh_representations (mesh_id, id);
+
+INSERT INTO mesh_representations (mesh_id, format, quad, face_limit, data)
+SELECT m.id, m.format, m.quad, m.face_limit, m.data
+FROM mesh_objects m
+WHERE NOT EXISTS (SELECT 1 FROM mesh_representations r WHERE r.mesh_id = m.id);
+
+CREATE TABLE IF NOT EXISTS generation_job_conversions (
+    id                SERIAL PRIMARY KEY,
+    job_id            INT     NOT NULL REFERENCES generation_jobs (id),
+    format            TEXT    NOT NULL,
+    quad              BOOLEAN NOT NULL,
+    face_limit        INT     NOT NULL,
+    task_id           TEXT    NOT NULL DEFAULT '',
+    status            TEXT    NOT NULL DEFAULT 'queued',
+    progress          INT     NOT NULL DEFAULT 0,
+    model_url         TEXT    NOT NULL DEFAULT '',
+    representation_id INT     REFERENCES mesh_representations (id),
+    error             TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS generation_job_conversions_job_idx ON generation_job_conversions (job_id, id);
+
+INSERT INTO generation_job_conversions (job_id, format, quad, face_limit, task_id, status, model_url)
+SELECT j.id, j.format, j.quad, j.face_limit, j.convert_task_id,
+    CASE WHEN j.model_url <> '' THEN 'success' ELSE 'queued' END, j.model_url
+FROM generation_jobs j
+WHERE NOT EXISTS (SELECT 1 FROM generation_job_conversions c WHERE c.job_id = j.id);
+
+ALTER TABLE generation_jobs
+    DROP COLUMN IF EXISTS convert_task_id,
+    DROP COLUMN IF EXISTS model_url;
@@ -0,0 +1,5 @@
+ALTER TABLE generation_jobs
+    ADD COLUMN IF NOT EXISTS mode            TEXT NOT NULL DEFAULT 'image',
+    ADD COLUMN IF NOT EXISTS prompt          TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS negative_prompt TEXT NOT NULL DEFAULT '',
+    ALTER COLUMN source_image DROP NOT NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS job_images (
+    id          SERIAL PRIMARY KEY,
+    job_id      INT         NOT NULL REFERENCES generation_jobs (id),
+    view        TEXT        NOT NULL,
+    filename    TEXT        NOT NULL,
+    data        BYTEA       NOT NULL,
+    image_token TEXT        NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (job_id, view)
+);
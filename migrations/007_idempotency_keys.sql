@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    client_id    TEXT        NOT NULL DEFAULT '',
+    key          TEXT        NOT NULL,
+    request_hash TEXT        NOT NULL,
+    job_id       INT         REFERENCES generation_jobs (id),
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at   TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (client_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_idx ON idempotency_keys (expires_at);
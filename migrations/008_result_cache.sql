@@ -0,0 +1,6 @@
+ALTER TABLE generation_jobs
+    ADD COLUMN IF NOT EXISTS cache_key          TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS cached_from_job_id INT REFERENCES generation_jobs (id);
+
+CREATE INDEX IF NOT EXISTS generation_jobs_cache_idx ON generation_jobs (cache_key, id)
+    WHERE status = 'succeeded' AND cache_key <> '';
@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS credit_costs (
+    task_type TEXT PRIMARY KEY,
+    cost      INT  NOT NULL
+);
+
+INSERT INTO credit_costs (task_type, cost) VALUES
+    ('image_to_model', 30),
+    ('text_to_model', 20),
+    ('multiview_to_model', 40),
+    ('convert_model', 5)
+ON CONFLICT (task_type) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS credit_balances (
+    user_id    INT         PRIMARY KEY REFERENCES users (id),
+    balance    INT         NOT NULL DEFAULT 0 CHECK (balance >= 0),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS credit_transactions (
+    id          SERIAL PRIMARY KEY,
+    user_id     INT         NOT NULL REFERENCES users (id),
+    job_id      INT         REFERENCES generation_jobs (id),
+    kind        TEXT        NOT NULL,
+    amount      INT         NOT NULL,
+    balance     INT         NOT NULL,
+    description TEXT        NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS credit_transactions_user_idx ON credit_transactions (user_id, id);
+
+ALTER TABLE generation_jobs
+    ADD COLUMN IF NOT EXISTS user_id          INT  REFERENCES users (id),
+    ADD COLUMN IF NOT EXISTS credits_reserved INT  NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS credits_state    TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS script_jobs (
+    id          SERIAL PRIMARY KEY,
+    status      TEXT        NOT NULL DEFAULT 'queued',
+    filename    TEXT        NOT NULL,
+    exit_code   INT,
+    stdout      TEXT        NOT NULL DEFAULT '',
+    stderr      TEXT        NOT NULL DEFAULT '',
+    mesh_id     INT         REFERENCES mesh_objects (id),
+    photo       BYTEA,
+    error       TEXT        NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at  TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS script_jobs_status_idx ON script_jobs (status, id);
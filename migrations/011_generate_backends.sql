@@ -0,0 +1,6 @@
+ALTER TABLE script_jobs
+    ADD COLUMN IF NOT EXISTS source_image BYTEA;
+
+ALTER TABLE generation_jobs
+    ADD COLUMN IF NOT EXISTS fallback               BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS fallback_script_job_id INT     REFERENCES script_jobs (id);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS generations (
+    id               SERIAL PRIMARY KEY,
+    job_id           INT         NOT NULL UNIQUE REFERENCES generation_jobs (id),
+    status           TEXT        NOT NULL DEFAULT 'queued',
+    generate_task_id TEXT        NOT NULL DEFAULT '',
+    model_url        TEXT        NOT NULL DEFAULT '',
+    model            BYTEA,
+    mesh_id          INT         REFERENCES mesh_objects (id),
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at      TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS generation_stages (
+    generation_id INT         NOT NULL REFERENCES generations (id),
+    stage         TEXT        NOT NULL,
+    started_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at   TIMESTAMPTZ,
+    PRIMARY KEY (generation_id, stage)
+);
+
+INSERT INTO generations (job_id, status, generate_task_id, mesh_id, created_at, finished_at)
+SELECT j.id, j.status, j.generate_task_id, j.mesh_id, j.created_at,
+    CASE WHEN j.status IN ('succeeded', 'failed', 'cancelled') THEN j.updated_at END
+FROM generation_jobs j
+WHERE NOT EXISTS (SELECT 1 FROM generations g WHERE g.job_id = j.id);
@@ -0,0 +1,35 @@
+-- File contents move to the blob store (BLOB_STORE). Rows keep the key, size
+-- and SHA-256 of their blob; data stays filled only for rows that
+-- cmd/blobmigrate has not moved yet.
+ALTER TABLE mesh_objects
+    ADD COLUMN IF NOT EXISTS blob_key    TEXT,
+    ADD COLUMN IF NOT EXISTS blob_size   BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS blob_sha256 TEXT   NOT NULL DEFAULT '',
+    ALTER COLUMN data DROP NOT NULL;
+
+ALTER TABLE mesh_representations
+    ADD COLUMN IF NOT EXISTS blob_key    TEXT,
+    ADD COLUMN IF NOT EXISTS blob_size   BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS blob_sha256 TEXT   NOT NULL DEFAULT '',
+    ALTER COLUMN data DROP NOT NULL;
+
+ALTER TABLE items
+    ADD COLUMN IF NOT EXISTS object_3d_key    TEXT,
+    ADD COLUMN IF NOT EXISTS object_3d_size   BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS object_3d_sha256 TEXT   NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS photo_key        TEXT,
+    ADD COLUMN IF NOT EXISTS photo_size       BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS photo_sha256     TEXT   NOT NULL DEFAULT '',
+    ALTER COLUMN object_3d DROP NOT NULL,
+    ALTER COLUMN photo DROP NOT NULL;
+
+ALTER TABLE generations
+    ADD COLUMN IF NOT EXISTS model_key    TEXT,
+    ADD COLUMN IF NOT EXISTS model_size   BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS model_sha256 TEXT   NOT NULL DEFAULT '';
+
+UPDATE mesh_objects SET blob_size = octet_length(data) WHERE blob_key IS NULL AND data IS NOT NULL;
+UPDATE mesh_representations SET blob_size = octet_length(data) WHERE blob_key IS NULL AND data IS NOT NULL;
+UPDATE items SET object_3d_size = octet_length(object_3d) WHERE object_3d_key IS NULL AND object_3d IS NOT NULL;
+UPDATE items SET photo_size = octet_length(photo) WHERE photo_key IS NULL AND photo IS NOT NULL;
+UPDATE generations SET model_size = octet_length(model) WHERE model_key IS NULL AND model IS NOT NULL;
@@ -0,0 +1,11 @@
+ALTER TABLE mesh_objects
+    ADD COLUMN IF NOT EXISTS owner_id INT REFERENCES users (id);
+
+UPDATE mesh_objects m SET owner_id = j.user_id
+FROM generation_jobs j
+WHERE j.mesh_id = m.id AND j.user_id IS NOT NULL AND j.cached_from_job_id IS NULL AND m.owner_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS mesh_objects_owner_idx ON mesh_objects (owner_id, id);
+CREATE INDEX IF NOT EXISTS mesh_objects_upload_time_idx ON mesh_objects (upload_time, id);
+CREATE INDEX IF NOT EXISTS mesh_objects_name_idx ON mesh_objects (name, id);
+CREATE INDEX IF NOT EXISTS generations_mesh_idx ON generations (mesh_id);
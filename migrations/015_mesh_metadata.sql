@@ -0,0 +1,9 @@
+-- Metadata read from the file when a mesh is saved. geometry stays NULL for
+-- formats that are not parsed and for rows saved before this migration.
+ALTER TABLE mesh_objects
+    ADD COLUMN IF NOT EXISTS detected_format TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS geometry        JSONB;
+
+ALTER TABLE mesh_representations
+    ADD COLUMN IF NOT EXISTS detected_format TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS geometry        JSONB;
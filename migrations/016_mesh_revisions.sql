@@ -0,0 +1,45 @@
+-- A mesh object is a logical asset with numbered revisions. Representations
+-- belong to a revision; the mesh_objects row mirrors the first
+-- representation of its current revision.
+CREATE TABLE IF NOT EXISTS mesh_revisions (
+    id         SERIAL PRIMARY KEY,
+    mesh_id    INT         NOT NULL REFERENCES mesh_objects (id),
+    number     INT         NOT NULL,
+    source     TEXT        NOT NULL DEFAULT '',
+    job_id     INT         REFERENCES generation_jobs (id),
+    params     JSONB       NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (mesh_id, number)
+);
+
+ALTER TABLE mesh_objects
+    ADD COLUMN IF NOT EXISTS current_revision_id INT REFERENCES mesh_revisions (id);
+
+ALTER TABLE mesh_representations
+    ADD COLUMN IF NOT EXISTS revision_id INT REFERENCES mesh_revisions (id);
+
+CREATE INDEX IF NOT EXISTS mesh_representations_revision_idx ON mesh_representations (revision_id, id);
+
+-- Regenerating into an existing mesh adds a revision to it instead of
+-- creating a new mesh object.
+ALTER TABLE generation_jobs
+    ADD COLUMN IF NOT EXISTS target_mesh_id INT REFERENCES mesh_objects (id);
+
+INSERT INTO mesh_revisions (mesh_id, number, source, job_id, params, created_at)
+SELECT m.id, 1, CASE WHEN j.id IS NULL THEN '' ELSE 'generation' END, j.id,
+    jsonb_build_object('format', m.format, 'quad', m.quad, 'face_limit', m.face_limit), m.upload_time
+FROM mesh_objects m
+LEFT JOIN LATERAL (
+    SELECT id FROM generation_jobs
+    WHERE mesh_id = m.id AND cached_from_job_id IS NULL
+    ORDER BY id LIMIT 1
+) j ON TRUE
+WHERE NOT EXISTS (SELECT 1 FROM mesh_revisions r WHERE r.mesh_id = m.id);
+
+UPDATE mesh_objects m SET current_revision_id = r.id
+FROM mesh_revisions r
+WHERE r.mesh_id = m.id AND r.number = 1 AND m.current_revision_id IS NULL;
+
+UPDATE mesh_representations p SET revision_id = m.current_revision_id
+FROM mesh_objects m
+WHERE m.id = p.mesh_id AND p.revision_id IS NULL;
@@ -0,0 +1,8 @@
+-- Deleting a mesh object only sets deleted_at. The row can be restored until
+-- the restore window has passed; then the collector removes it together with
+-- its revisions and representations.
+ALTER TABLE mesh_objects
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS mesh_objects_deleted_idx ON mesh_objects (deleted_at)
+    WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,19 @@
+-- Webhooks are queued here before they are sent, so retries survive a
+-- restart. Messages for the same job and URL are delivered in id order: a
+-- message is only sent once every earlier one is delivered or given up.
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+    id              BIGSERIAL PRIMARY KEY,
+    job_id          INT         NOT NULL REFERENCES generation_jobs (id),
+    url             TEXT        NOT NULL,
+    secret          TEXT        NOT NULL,
+    event           TEXT        NOT NULL,
+    payload         TEXT        NOT NULL,
+    status          TEXT        NOT NULL DEFAULT 'pending',
+    attempts        INT         NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until    TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS webhook_outbox_pending_idx ON webhook_outbox (job_id, url, id)
+    WHERE status = 'pending';
@@ -0,0 +1,10 @@
+-- Sessions issued by /api/login. Only the SHA-256 of the token is stored,
+-- so the table does not hold usable credentials.
+CREATE TABLE IF NOT EXISTS user_sessions (
+    token_sha256 TEXT        PRIMARY KEY,
+    user_id      INT         NOT NULL REFERENCES users (id),
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS user_sessions_expires_idx ON user_sessions (expires_at);
@@ -0,0 +1,14 @@
+-- Generation jobs run by the local script are tracked like cloud jobs.
+-- Cloud workers only claim cloud jobs; a local job is finished from the
+-- result of its script job.
+ALTER TABLE generation_jobs
+    ADD COLUMN IF NOT EXISTS backend         TEXT NOT NULL DEFAULT 'cloud',
+    ADD COLUMN IF NOT EXISTS fallback_reason TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE script_jobs
+    ADD COLUMN IF NOT EXISTS owner_id          INT REFERENCES users (id),
+    ADD COLUMN IF NOT EXISTS generation_job_id INT REFERENCES generation_jobs (id);
+
+INSERT INTO credit_costs (task_type, cost) VALUES
+    ('local_image_to_model', 10)
+ON CONFLICT (task_type) DO NOTHING;
@@ -0,0 +1,5 @@
+-- Results served from the cache are charged to the requesting user at this
+-- price instead of the price of a generation.
+INSERT INTO credit_costs (task_type, cost) VALUES
+    ('cached_result', 5)
+ON CONFLICT (task_type) DO NOTHING;
@@ -0,0 +1,28 @@
+-- Source images of generation and script jobs and the photos rendered by
+-- the script move to the blob store like the other files (see 013). The
+-- bytea columns stay filled only for rows cmd/blobmigrate has not moved yet.
+ALTER TABLE generation_jobs
+    ADD COLUMN IF NOT EXISTS source_image_key    TEXT,
+    ADD COLUMN IF NOT EXISTS source_image_size   BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS source_image_sha256 TEXT   NOT NULL DEFAULT '';
+
+ALTER TABLE job_images
+    ADD COLUMN IF NOT EXISTS data_key    TEXT,
+    ADD COLUMN IF NOT EXISTS data_size   BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS data_sha256 TEXT   NOT NULL DEFAULT '',
+    ALTER COLUMN data DROP NOT NULL;
+
+ALTER TABLE script_jobs
+    ADD COLUMN IF NOT EXISTS source_image_key    TEXT,
+    ADD COLUMN IF NOT EXISTS source_image_size   BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS source_image_sha256 TEXT   NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS photo_key           TEXT,
+    ADD COLUMN IF NOT EXISTS photo_size          BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS photo_sha256        TEXT   NOT NULL DEFAULT '';
+
+UPDATE generation_jobs SET source_image_size = octet_length(source_image)
+    WHERE source_image_key IS NULL AND source_image IS NOT NULL;
+UPDATE job_images SET data_size = octet_length(data) WHERE data_key IS NULL AND data IS NOT NULL;
+UPDATE script_jobs SET source_image_size = octet_length(source_image)
+    WHERE source_image_key IS NULL AND source_image IS NOT NULL;
+UPDATE script_jobs SET photo_size = octet_length(photo) WHERE photo_key IS NULL AND photo IS NOT NULL;